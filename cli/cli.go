@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"secure_vault/vault"
+)
+
+// Exit codes of the command-line interface
+const (
+	ExitOK        = 0 // Command succeeded
+	ExitError     = 1 // Command failed
+	ExitUsage     = 2 // Command line is invalid
+	ExitAuth      = 3 // Credentials don't unlock the vault
+	ExitIntegrity = 4 // Vault or file integrity check failed, or the vault file is damaged
+	ExitNotVault  = 5 // File is not a vault, or its format version is unsupported
+	ExitInUse     = 6 // Vault is open for writing in another process
+)
+
+const programName = "secure_vault"
+
+// Command is a subcommand of the command-line interface
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(env *environment, args []string) error
+}
+
+// Streams the commands read from and write to
+type environment struct {
+	stdin   *lineReader
+	stdout  io.Writer
+	stderr  io.Writer
+	command *command // Command being run
+}
+
+// Errors carrying their own exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+var commands = []command{
+	{"create", "create [flags] VAULT", "Create a new vault", runCreate},
+	{"ls", "ls [flags] VAULT", "List the files in a vault", runList},
+	{"add", "add [flags] VAULT FILE...", "Add files and folders to a vault", runAdd},
+	{"extract", "extract [flags] VAULT PATH...", "Extract copies of files and folders from a vault", runExtract},
+	{"rm", "rm [flags] VAULT PATH...", "Remove files from a vault", runRemove},
+	{"verify", "verify [flags] VAULT", "Check the integrity of a vault and its files", runVerify},
+	{"info", "info [flags] VAULT", "Show the vault header, key slots and settings", runInfo},
+	{"passwd", "passwd [flags] VAULT", "Change the credentials of a key slot", runPasswd},
+}
+
+// Run executes the command line and returns the exit code
+func Run(args []string) int {
+	env := &environment{
+		stdin:  newLineReader(os.Stdin),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(env.stderr)
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+
+	// Find the subcommand
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		env.command = &cmd
+		err := cmd.run(env, args[1:])
+		if err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(env.stderr, "%s %s: %v\n", programName, cmd.name, err)
+			}
+			return exitCode(err)
+		}
+		return ExitOK
+	}
+
+	fmt.Fprintf(env.stderr, "%s: unknown command: %s\n", programName, args[0])
+	printUsage(env.stderr)
+	return ExitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s COMMAND [flags] VAULT [ARGS...]\n\nCommands:\n", programName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"%s COMMAND -h\" for the flags of a command.\n", programName)
+	fmt.Fprintf(w, "Without a command the graphical interface is started.\n")
+}
+
+// Maps an error to the exit code of the command
+func exitCode(err error) int {
+	var exitErr *exitError
+	var inUseErr *vault.VaultInUseError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, vault.ErrWrongPassword):
+		return ExitAuth
+	case errors.Is(err, vault.ErrIntegrityMismatch), errors.Is(err, vault.ErrCorruptHeader), errors.Is(err, vault.ErrTruncated),
+		errors.Is(err, vault.ErrCorruptJournal):
+		return ExitIntegrity
+	case errors.Is(err, vault.ErrNotVault), errors.Is(err, vault.ErrUnsupportedVersion):
+		return ExitNotVault
+	case errors.As(err, &inUseErr), errors.Is(err, vault.ErrUnfinishedSave):
+		return ExitInUse
+	default:
+		return ExitError
+	}
+}
+
+func usageError(format string, a ...interface{}) error {
+	return &exitError{code: ExitUsage, err: fmt.Errorf(format, a...)}
+}
+
+// Returns a flag set for the command being run that reports errors to stderr
+func newFlagSet(env *environment) *flag.FlagSet {
+	cmd := env.command
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(env.stderr, "Usage: %s %s\n\n%s\n\nFlags:\n", programName, cmd.usage, cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parses the flags and checks the number of positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, minArgs int, maxArgs int) error {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	if err != nil {
+		return &exitError{code: ExitUsage, err: err}
+	}
+
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		flags.Usage()
+		return usageError("wrong number of arguments")
+	}
+
+	return nil
+}
@@ -0,0 +1,500 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"secure_vault/vault"
+	vaultUtils "secure_vault/vault/utils"
+	"strings"
+	"text/tabwriter"
+)
+
+func runCreate(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	cipherName := flags.String("cipher", vaultUtils.DefaultCipherSuite.String(), "cipher suite (AES-256-GCM or XChaCha20-Poly1305)")
+	kdfPreset := flags.String("kdf", vaultUtils.KDFPresets[0].Name, "key derivation preset (Interactive, Moderate or Paranoid)")
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	// Validation
+	cipherSuite, err := vaultUtils.ParseCipherSuite(*cipherName)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	kdfParams, err := vaultUtils.FindKDFPreset(*kdfPreset)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	_, err = os.Stat(vaultPath)
+	if err == nil {
+		return fmt.Errorf("file already exists: %s", vaultPath)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	credentials, err := credentialFlags.credentials(env, "Password", true)
+	if err != nil {
+		return err
+	}
+
+	// Create and save the vault
+	v, key, err := vault.CreateVault(credentials, cipherSuite, kdfParams)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	return vault.SaveVault(v, key, vaultPath)
+}
+
+func runList(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	jsonOutput := flags.Bool("json", false, "print the files as JSON")
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	v, _, err := openVault(env, credentialFlags, vaultPath, true)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	if *jsonOutput {
+		document := jsonList{
+			SchemaVersion: jsonSchemaVersion,
+			Vault:         vaultPath,
+			Folders:       []string{},
+			Files:         []jsonFile{},
+		}
+		document.Folders = append(document.Folders, v.Folders...)
+		for i, fileMetadata := range v.FilesMetadata {
+			document.Files = append(document.Files, newJSONFile(i, fileMetadata))
+		}
+		return writeJSON(env.stdout, document)
+	}
+
+	// Print the files as a table
+	table := tabwriter.NewWriter(env.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSIZE\tADDED\tPATH")
+	for _, folder := range v.Folders {
+		fmt.Fprintf(table, "-\t-\t-\t%s/\n", folder)
+	}
+	for _, fileMetadata := range v.FilesMetadata {
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\n",
+			fileMetadata.ID, fileMetadata.Size, fileMetadata.AddedAt.Format("2006-01-02 15:04"), fileMetadata.Path())
+	}
+	return table.Flush()
+}
+
+func runAdd(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	deleteFiles := flags.Bool("delete", false, "delete the original files once they are added")
+	folderPath := flags.String("folder", "", "vault `folder` the files are added into, created if missing")
+	transferFlags := addTransferFlags(flags, true)
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	options, err := transferFlags.options(env)
+	if err != nil {
+		return err
+	}
+
+	v, key, err := openVault(env, credentialFlags, vaultPath, false)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	err = vault.CreateFolder(v, *folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Add the files and folders, the originals are only deleted after the vault is saved
+	filePaths := flags.Args()[1:]
+	failures := 0
+	for _, filePath := range filePaths {
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+
+		if stat.IsDir() && *deleteFiles {
+			return usageError("-delete can't be used with folders: %s", filePath)
+		}
+
+		if !stat.IsDir() {
+			err = vault.AddFileToVault(v, key, filePath, *folderPath, false, transferFlags.skipAttributes())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Folders are imported with their content, failed files don't stop the import
+		summary, err := vault.ImportFolder(v, key, filePath, *folderPath, options)
+		if summary != nil {
+			printTransferSummary(env, filePath, summary)
+			failures += summary.Failed
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = vault.SaveVault(v, key, vaultPath)
+	if err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d file(s) could not be added", failures)
+	}
+
+	if *deleteFiles {
+		for _, filePath := range filePaths {
+			err = os.Remove(filePath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func runExtract(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	outputFolder := flags.String("out", ".", "`folder` the files are extracted into")
+	transferFlags := addTransferFlags(flags, false)
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	options, err := transferFlags.options(env)
+	if err != nil {
+		return err
+	}
+
+	v, key, err := openVault(env, credentialFlags, flags.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	// Folders are exported with their content, the other paths must be files
+	var folderPaths, filePaths []string
+	for _, vaultPath := range flags.Args()[1:] {
+		if vault.FolderExists(v, strings.Trim(vaultPath, "/")) {
+			folderPaths = append(folderPaths, vaultPath)
+		} else {
+			filePaths = append(filePaths, vaultPath)
+		}
+	}
+	fileIDs, err := findFiles(v, filePaths)
+	if err != nil {
+		return err
+	}
+
+	// Extract copies of the files, the vault is left untouched
+	exportOptions := vault.ExportOptions{
+		SkipAttributes: options.SkipAttributes,
+		RestoreOwner:   options.RestoreOwner,
+		Conflict:       options.Conflict,
+		Resolve:        options.Resolve,
+	}
+	for _, fileID := range fileIDs {
+		_, err = vault.ExportFile(v, key, fileID, *outputFolder, exportOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Recreate the folder trees, failed files don't stop the export
+	failures := 0
+	for _, folderPath := range folderPaths {
+		summary, err := vault.ExportFolder(v, key, folderPath, *outputFolder, options)
+		if err != nil {
+			return err
+		}
+		printTransferSummary(env, folderPath, summary)
+		failures += summary.Failed
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d file(s) could not be extracted", failures)
+	}
+	return nil
+}
+
+func runRemove(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	v, key, err := openVault(env, credentialFlags, vaultPath, false)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	fileIDs, err := findFiles(v, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, fileID := range fileIDs {
+		err = vault.RemoveFileFromVault(v, fileID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return vault.SaveVault(v, key, vaultPath)
+}
+
+func runVerify(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	jsonOutput := flags.Bool("json", false, "print the results as JSON")
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	v, key, err := openVault(env, credentialFlags, vaultPath, true)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	// Check the vault integrity
+	vaultIntegrity, err := vault.CheckVaultIntegrity(vaultPath, key)
+	if err != nil {
+		return err
+	}
+	document := jsonVerify{
+		SchemaVersion:  jsonSchemaVersion,
+		Vault:          vaultPath,
+		VaultIntegrity: vaultIntegrity,
+		Files:          []jsonFile{},
+	}
+	failures := 0
+	if !vaultIntegrity {
+		failures++
+	}
+
+	// Check the integrity of every file
+	for i, fileMetadata := range v.FilesMetadata {
+		integrity, err := verifyFile(v, key, fileMetadata.ID)
+		file := newJSONFile(i, fileMetadata)
+		file.Integrity = &integrity
+		if err != nil {
+			file.Error = err.Error()
+		}
+		if !integrity {
+			failures++
+		}
+		document.Files = append(document.Files, file)
+	}
+	document.OK = failures == 0
+
+	if *jsonOutput {
+		err = writeJSON(env.stdout, document)
+		if err != nil {
+			return err
+		}
+	} else {
+		printVerifyResult(env, filepath.Base(vaultPath), vaultIntegrity, "")
+		for _, file := range document.Files {
+			printVerifyResult(env, file.Path, *file.Integrity, file.Error)
+		}
+	}
+
+	if failures > 0 {
+		return &exitError{code: ExitIntegrity, err: fmt.Errorf("%d integrity check(s) failed", failures)}
+	}
+	return nil
+}
+
+// Checks the MAC of the file, then authenticates its content chunk by chunk
+func verifyFile(v *vault.Vault, key []byte, fileID string) (bool, error) {
+	integrity, err := vault.CheckFileIntegrity(v, key, fileID)
+	if err != nil || !integrity {
+		return false, err
+	}
+
+	err = vault.VerifyFile(v, key, fileID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func printVerifyResult(env *environment, name string, integrity bool, errorMessage string) {
+	switch {
+	case integrity:
+		fmt.Fprintf(env.stdout, "OK      %s\n", name)
+	case errorMessage != "":
+		fmt.Fprintf(env.stdout, "FAILED  %s: %s\n", name, errorMessage)
+	default:
+		fmt.Fprintf(env.stdout, "FAILED  %s\n", name)
+	}
+}
+
+func runInfo(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	jsonOutput := flags.Bool("json", false, "print the details as JSON")
+	credentialFlags := addCredentialFlags(flags, "")
+	err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	v, _, err := openVault(env, credentialFlags, vaultPath, true)
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	var totalSize int64
+	for _, fileMetadata := range v.FilesMetadata {
+		totalSize += fileMetadata.Size
+	}
+
+	if *jsonOutput {
+		document := jsonInfo{
+			SchemaVersion: jsonSchemaVersion,
+			Vault:         vaultPath,
+			FormatVersion: v.Header.Version,
+			Cipher:        v.Header.Cipher.String(),
+			KDF:           v.Header.KDF.String(),
+			CreatedAt:     v.Metadata.CreatedAt,
+			FileCount:     len(v.FilesMetadata),
+			TotalSize:     totalSize,
+			DeadSpace:     vault.DeadSpace(v),
+			Backups: jsonBackups{
+				Count:  v.Backups.Count,
+				Folder: v.Backups.Folder,
+			},
+			KeySlots: []jsonKeySlot{},
+		}
+		for _, keySlot := range vault.ListKeySlots(v) {
+			document.KeySlots = append(document.KeySlots, newJSONKeySlot(keySlot))
+		}
+		return writeJSON(env.stdout, document)
+	}
+
+	backupFolder := v.Backups.Folder
+	if backupFolder == "" {
+		backupFolder = "(vault folder)"
+	}
+
+	// Print the vault details
+	table := tabwriter.NewWriter(env.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Format Version:\t%d\n", v.Header.Version)
+	fmt.Fprintf(table, "Cipher:\t%s\n", v.Header.Cipher)
+	fmt.Fprintf(table, "Key Derivation:\t%s\n", v.Header.KDF)
+	fmt.Fprintf(table, "Created At:\t%s\n", v.Metadata.CreatedAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(table, "Files:\t%d (%d bytes)\n", len(v.FilesMetadata), totalSize)
+	fmt.Fprintf(table, "Dead Space:\t%d bytes\n", vault.DeadSpace(v))
+	fmt.Fprintf(table, "Backups:\t%d in %s\n", v.Backups.Count, backupFolder)
+	fmt.Fprintf(table, "Key Slots:\t%d\n", len(v.Metadata.KeySlots))
+	for _, keySlot := range vault.ListKeySlots(v) {
+		fmt.Fprintf(table, "  %s\t%s, time %d, memory %d KiB, threads %d, created %s\n",
+			keySlot.Label, keySlot.Factors, keySlot.KDFParams.Time, keySlot.KDFParams.Memory, keySlot.KDFParams.Threads,
+			keySlot.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return table.Flush()
+}
+
+func runPasswd(env *environment, args []string) error {
+	flags := newFlagSet(env)
+	credentialFlags := addCredentialFlags(flags, "")
+	newCredentialFlags := addCredentialFlags(flags, "new-")
+	err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	vaultPath := flags.Arg(0)
+
+	oldCredentials, err := credentialFlags.credentials(env, "Current password", false)
+	if err != nil {
+		return err
+	}
+
+	v, key, err := vault.LoadVault(oldCredentials, vaultPath, vault.LoadOptions{})
+	if err != nil {
+		return err
+	}
+	defer vault.CloseVault(v)
+
+	newCredentials, err := newCredentialFlags.credentials(env, "New password", true)
+	if err != nil {
+		return err
+	}
+
+	// Rewrap the master key of the key slot unlocked by the current credentials
+	err = vault.ChangeCredentials(v, oldCredentials, newCredentials)
+	if err != nil {
+		return err
+	}
+
+	// The save deletes the generations, the old credentials still unlock them
+	backups, _ := vault.ListBackups(v, key)
+	err = vault.SaveVault(v, key, vaultPath)
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 {
+		fmt.Fprintf(env.stderr, "Deleted %d backup(s) the old credentials unlock\n", len(backups))
+	}
+	return nil
+}
+
+// Reads the credentials and loads the vault, read-only for the commands that don't save it
+func openVault(env *environment, credentialFlags *credentialFlags, vaultPath string, readOnly bool) (*vault.Vault, []byte, error) {
+	credentials, err := credentialFlags.credentials(env, "Password", false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vault.LoadVault(credentials, vaultPath, vault.LoadOptions{ReadOnly: readOnly})
+}
+
+// Returns the IDs of the files with the given paths
+func findFiles(v *vault.Vault, filePaths []string) ([]string, error) {
+	var fileIDs []string
+	for _, filePath := range filePaths {
+		fileMetadata, err := vault.FileByPath(v, filePath)
+		if err != nil {
+			return nil, err
+		}
+		fileIDs = append(fileIDs, fileMetadata.ID)
+	}
+	return fileIDs, nil
+}
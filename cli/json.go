@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"secure_vault/vault"
+	"time"
+)
+
+/*
+	JSON output of the ls, verify and info commands. Every document carries
+	the schema version, which is increased whenever a field is removed or
+	changes meaning. New fields may be added without changing the version.
+*/
+
+// Version 2: files are identified by id, index became their position in the
+// document instead of their index in the vault
+const jsonSchemaVersion = 2
+
+// File entry of the ls and verify documents
+type jsonFile struct {
+	ID        string     `json:"id"`    // Stable identifier of the file
+	Index     int        `json:"index"` // Position in the document
+	Name      string     `json:"name"`
+	Folder    string     `json:"folder"` // Empty for the root folder
+	Path      string     `json:"path"`
+	Size      int64      `json:"size"`
+	AddedAt   time.Time  `json:"added_at"`
+	Mode      string     `json:"mode,omitempty"`        // Permission bits of the original file, if recorded
+	ModTime   *time.Time `json:"modified_at,omitempty"` // Modification time of the original file, if recorded
+	Integrity *bool      `json:"integrity,omitempty"`   // Only in verify
+	Error     string     `json:"error,omitempty"`       // Only in verify, why the file can't be verified
+}
+
+// Document of the ls command
+type jsonList struct {
+	SchemaVersion int        `json:"schema_version"`
+	Vault         string     `json:"vault"`
+	Folders       []string   `json:"folders"`
+	Files         []jsonFile `json:"files"`
+}
+
+// Document of the verify command
+type jsonVerify struct {
+	SchemaVersion  int        `json:"schema_version"`
+	Vault          string     `json:"vault"`
+	OK             bool       `json:"ok"`              // Vault and every file passed
+	VaultIntegrity bool       `json:"vault_integrity"` // Result of CheckVaultIntegrity
+	Files          []jsonFile `json:"files"`
+}
+
+// Document of the info command
+type jsonInfo struct {
+	SchemaVersion int           `json:"schema_version"`
+	Vault         string        `json:"vault"`
+	FormatVersion uint16        `json:"format_version"`
+	Cipher        string        `json:"cipher"`
+	KDF           string        `json:"kdf"`
+	CreatedAt     time.Time     `json:"created_at"`
+	FileCount     int           `json:"file_count"`
+	TotalSize     int64         `json:"total_size"`
+	DeadSpace     int64         `json:"dead_space"`
+	Backups       jsonBackups   `json:"backups"`
+	KeySlots      []jsonKeySlot `json:"key_slots"`
+}
+
+type jsonBackups struct {
+	Count  int    `json:"count"`
+	Folder string `json:"folder"` // Empty for the folder of the vault
+}
+
+type jsonKeySlot struct {
+	Label     string    `json:"label"`
+	Factors   []string  `json:"factors"`
+	KDF       jsonKDF   `json:"kdf"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type jsonKDF struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory_kib"`
+	Threads uint8  `json:"threads"`
+}
+
+func newJSONFile(index int, fileMetadata vault.FileMetadata) jsonFile {
+	file := jsonFile{
+		ID:      fileMetadata.ID,
+		Index:   index,
+		Name:    fileMetadata.Name,
+		Folder:  fileMetadata.Folder,
+		Path:    fileMetadata.Path(),
+		Size:    fileMetadata.Size,
+		AddedAt: fileMetadata.AddedAt,
+	}
+
+	attributes := fileMetadata.Attributes
+	if attributes.Recorded&vault.AttributeMode != 0 {
+		file.Mode = fmt.Sprintf("%04o", uint32(attributes.Mode.Perm()))
+	}
+	if attributes.Recorded&vault.AttributeTimes != 0 {
+		file.ModTime = &attributes.ModTime
+	}
+	return file
+}
+
+func newJSONKeySlot(keySlot vault.KeySlot) jsonKeySlot {
+	factors := []string{}
+	if keySlot.Factors&vault.FactorPassword != 0 {
+		factors = append(factors, "password")
+	}
+	if keySlot.Factors&vault.FactorKeyfile != 0 {
+		factors = append(factors, "keyfile")
+	}
+
+	return jsonKeySlot{
+		Label:   keySlot.Label,
+		Factors: factors,
+		KDF: jsonKDF{
+			Time:    keySlot.KDFParams.Time,
+			Memory:  keySlot.KDFParams.Memory,
+			Threads: keySlot.KDFParams.Threads,
+		},
+		CreatedAt: keySlot.CreatedAt,
+	}
+}
+
+func writeJSON(w io.Writer, document interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"secure_vault/vault"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Secrets of a key slot given on the command line
+type credentialFlags struct {
+	passwordStdin bool
+	passwordEnv   string
+	passwordFD    int
+	keyfile       string
+}
+
+// Registers the credential flags, with a prefix for the new credentials of passwd
+func addCredentialFlags(flags *flag.FlagSet, prefix string) *credentialFlags {
+	c := &credentialFlags{}
+	flags.BoolVar(&c.passwordStdin, prefix+"password-stdin", false, "read the "+prefix+"password from the first line of stdin")
+	flags.StringVar(&c.passwordEnv, prefix+"password-env", "", "read the "+prefix+"password from the environment `variable`")
+	flags.IntVar(&c.passwordFD, prefix+"password-fd", -1, "read the "+prefix+"password from the first line of the file `descriptor`")
+	flags.StringVar(&c.keyfile, prefix+"keyfile", "", "path of the "+prefix+"keyfile")
+	return c
+}
+
+// Reads the credentials from the chosen source, or prompts for the password
+// on the terminal. The password is asked twice if confirm is set.
+func (c *credentialFlags) credentials(env *environment, prompt string, confirm bool) (vault.Credentials, error) {
+	credentials := vault.Credentials{KeyfilePath: c.keyfile}
+
+	// Check only one password source is chosen
+	sources := 0
+	if c.passwordStdin {
+		sources++
+	}
+	if c.passwordEnv != "" {
+		sources++
+	}
+	if c.passwordFD >= 0 {
+		sources++
+	}
+	if sources > 1 {
+		return credentials, usageError("only one password source can be used")
+	}
+
+	var err error
+	switch {
+	case c.passwordStdin:
+		credentials.Password, err = env.stdin.readLine()
+		if err != nil {
+			return credentials, fmt.Errorf("reading password from stdin: %w", err)
+		}
+	case c.passwordEnv != "":
+		password, ok := os.LookupEnv(c.passwordEnv)
+		if !ok {
+			return credentials, fmt.Errorf("environment variable not set: %s", c.passwordEnv)
+		}
+		credentials.Password = password
+	case c.passwordFD >= 0:
+		credentials.Password, err = readPasswordFD(c.passwordFD)
+		if err != nil {
+			return credentials, err
+		}
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		credentials.Password, err = promptPassword(env, prompt, confirm)
+		if err != nil {
+			return credentials, err
+		}
+	case c.keyfile == "":
+		return credentials, usageError("stdin is not a terminal, choose a password source or a keyfile")
+	}
+
+	if credentials.Factors() == 0 {
+		return credentials, usageError("password or keyfile is required")
+	}
+
+	return credentials, nil
+}
+
+func promptPassword(env *environment, prompt string, confirm bool) (string, error) {
+	// Read the password without echo
+	fmt.Fprintf(env.stderr, "%s: ", prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(env.stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if confirm {
+		fmt.Fprintf(env.stderr, "Confirm %s: ", strings.ToLower(prompt))
+		confirmation, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(env.stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(confirmation) != string(password) {
+			return "", fmt.Errorf("passwords do not match")
+		}
+	}
+
+	return string(password), nil
+}
+
+func readPasswordFD(fd int) (string, error) {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if file == nil {
+		return "", fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+	defer file.Close()
+
+	password, err := newLineReader(file).readLine()
+	if err != nil {
+		return "", fmt.Errorf("reading password from file descriptor %d: %w", fd, err)
+	}
+	return password, nil
+}
+
+// Reads lines one by one, so several passwords can be piped into stdin
+type lineReader struct {
+	reader *bufio.Reader
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{reader: bufio.NewReader(r)}
+}
+
+func (r *lineReader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
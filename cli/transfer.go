@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"secure_vault/vault"
+	"strings"
+)
+
+// Repeatable flag collecting glob patterns
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(pattern string) error {
+	*p = append(*p, pattern)
+	return nil
+}
+
+// Options of the folder imports and exports given on the command line
+type transferFlags struct {
+	include        patternList
+	exclude        patternList
+	followSymlinks bool
+	conflict       string
+	noMode         bool
+	noTimes        bool
+	noOwner        bool
+	owner          bool
+	noXattrs       bool
+}
+
+// Registers the transfer flags, the symbolic link flag only applies to imports
+// and the conflict and owner flags to exports. The attribute and conflict
+// flags also apply to single files. Owners are recorded unless opted out, but
+// only restored on request.
+func addTransferFlags(flags *flag.FlagSet, imports bool) *transferFlags {
+	t := &transferFlags{}
+	flags.Var(&t.include, "include", "only transfer the files matching the glob `pattern` (repeatable)")
+	flags.Var(&t.exclude, "exclude", "leave out the files and folders matching the glob `pattern` (repeatable)")
+	if imports {
+		flags.BoolVar(&t.followSymlinks, "follow-symlinks", false, "import the targets of symbolic links instead of skipping them")
+	} else {
+		flags.StringVar(&t.conflict, "conflict", vault.ConflictFail.String(), "what happens to existing files (fail, skip, overwrite, rename or ask)")
+	}
+	flags.BoolVar(&t.noMode, "no-mode", false, "don't keep the permission bits")
+	flags.BoolVar(&t.noTimes, "no-times", false, "don't keep the modification and access times")
+	if imports {
+		flags.BoolVar(&t.noOwner, "no-owner", false, "don't keep the user and group IDs")
+	} else {
+		flags.BoolVar(&t.owner, "owner", false, "restore the user and group IDs")
+	}
+	flags.BoolVar(&t.noXattrs, "no-xattrs", false, "don't keep the extended attributes")
+	return t
+}
+
+// Returns the file attributes opted out of
+func (t *transferFlags) skipAttributes() vault.Attributes {
+	var skip vault.Attributes
+	if t.noMode {
+		skip |= vault.AttributeMode
+	}
+	if t.noTimes {
+		skip |= vault.AttributeTimes
+	}
+	if t.noOwner {
+		skip |= vault.AttributeOwner
+	}
+	if t.noXattrs {
+		skip |= vault.AttributeXattrs
+	}
+	return skip
+}
+
+func (t *transferFlags) options(env *environment) (vault.TransferOptions, error) {
+	options := vault.TransferOptions{
+		Include:        t.include,
+		Exclude:        t.exclude,
+		Symlinks:       vault.SymlinkSkip,
+		SkipAttributes: t.skipAttributes(),
+		RestoreOwner:   t.owner,
+	}
+	if t.followSymlinks {
+		options.Symlinks = vault.SymlinkFollow
+	}
+
+	if t.conflict != "" {
+		conflict, err := vault.ParseConflictPolicy(t.conflict)
+		if err != nil {
+			return options, usageError("%v", err)
+		}
+		options.Conflict = conflict
+		options.Resolve = func(filePath string) vault.ConflictPolicy {
+			return askConflict(env, filePath)
+		}
+	}
+
+	return options, nil
+}
+
+// Asks on stderr what happens to the existing file, failing if no answer can be read
+func askConflict(env *environment, filePath string) vault.ConflictPolicy {
+	for {
+		fmt.Fprintf(env.stderr, "%s already exists, [s]kip, [o]verwrite or [r]ename? ", filePath)
+		answer, err := env.stdin.readLine()
+		if err != nil {
+			fmt.Fprintln(env.stderr)
+			return vault.ConflictFail
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "s", "skip":
+			return vault.ConflictSkip
+		case "o", "overwrite":
+			return vault.ConflictOverwrite
+		case "r", "rename":
+			return vault.ConflictRename
+		}
+	}
+}
+
+// Prints the result of every file of a folder transfer, then the summary
+func printTransferSummary(env *environment, folderName string, summary *vault.TransferSummary) {
+	for _, result := range summary.Results {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(env.stdout, "FAILED   %s: %v\n", result.Path, result.Err)
+		case result.Skipped:
+			fmt.Fprintf(env.stdout, "SKIPPED  %s\n", result.Path)
+		default:
+			fmt.Fprintf(env.stdout, "OK       %s\n", result.Path)
+		}
+	}
+	fmt.Fprintf(env.stdout, "%s: %s\n", folderName, summary)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"secure_vault/cli"
 	"secure_vault/ui"
 
 	"fyne.io/fyne/v2"
@@ -8,6 +10,11 @@ import (
 )
 
 func main() {
+	// Run the command-line interface if a command is given
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	secureVaultApp := app.NewWithID("securevaultapp")
 	mainWindow := secureVaultApp.NewWindow("Secure Vault Manager")
 
@@ -0,0 +1,155 @@
+package ui
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	autoLockPreference      = "autoLockMinutes" // Idle minutes before the vault is locked, 0 disables
+	defaultAutoLockMinutes  = 5
+	autoLockSaveGracePeriod = time.Minute // Time to answer the unsaved changes question of an idle lock
+)
+
+// Choices of the auto-lock setting in minutes
+var autoLockChoices = []int{0, 1, 5, 15, 30, 60}
+
+// Shortcut locking the vault
+var lockShortcut = &desktop.CustomShortcut{KeyName: fyne.KeyL, Modifier: fyne.KeyModifierShortcutDefault}
+
+// Locks the vault when nothing happened for the idle timeout
+type autoLock struct {
+	mutex   sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+	holds   int // Running transfers, the timeout is suspended until they end
+	lock    func()
+}
+
+func newAutoLock(timeout time.Duration, lock func()) *autoLock {
+	a := &autoLock{lock: lock}
+	a.setTimeout(timeout)
+	return a
+}
+
+// Restarts the idle timeout
+func (a *autoLock) touch() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.timer != nil && a.holds == 0 {
+		a.timer.Reset(a.timeout)
+	}
+}
+
+// Suspends the idle timeout until every hold is released
+func (a *autoLock) hold() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.holds++
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+// Restarts the idle timeout once the last hold is released
+func (a *autoLock) release() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.holds--
+	if a.timer != nil && a.holds == 0 {
+		a.timer.Reset(a.timeout)
+	}
+}
+
+// Changes the idle timeout, 0 disables the auto-lock
+func (a *autoLock) setTimeout(timeout time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.timeout = timeout
+	if timeout > 0 {
+		a.timer = time.AfterFunc(timeout, a.lock)
+		if a.holds > 0 {
+			a.timer.Stop()
+		}
+	}
+}
+
+func (a *autoLock) stop() {
+	a.setTimeout(0)
+}
+
+// Restarts the idle timeout whenever one of the buttons is tapped
+func (a *autoLock) watchButtons(objects []fyne.CanvasObject) {
+	for _, object := range objects {
+		if button, ok := object.(*widget.Button); ok {
+			onTapped := button.OnTapped
+			button.OnTapped = func() {
+				a.touch()
+				onTapped()
+			}
+		}
+	}
+}
+
+// Returns the auto-lock timeout chosen in the preferences
+func autoLockTimeout(app fyne.App) time.Duration {
+	minutes := app.Preferences().IntWithFallback(autoLockPreference, defaultAutoLockMinutes)
+	return time.Duration(minutes) * time.Minute
+}
+
+// Asks for the idle minutes before the vault is locked
+func showAutoLockDialog(app fyne.App, window fyne.Window, changed func(timeout time.Duration)) {
+	var options []string
+	selected := ""
+	current := app.Preferences().IntWithFallback(autoLockPreference, defaultAutoLockMinutes)
+	for _, minutes := range autoLockChoices {
+		options = append(options, autoLockChoiceName(minutes))
+		if minutes == current {
+			selected = autoLockChoiceName(minutes)
+		}
+	}
+	timeoutSelect := widget.NewSelect(options, nil)
+	timeoutSelect.SetSelected(selected)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Lock After", timeoutSelect),
+	}
+
+	dialog.ShowForm("Auto-Lock", "Apply", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		for _, minutes := range autoLockChoices {
+			if autoLockChoiceName(minutes) == timeoutSelect.Selected {
+				app.Preferences().SetInt(autoLockPreference, minutes)
+				changed(time.Duration(minutes) * time.Minute)
+			}
+		}
+	}, window)
+}
+
+func autoLockChoiceName(minutes int) string {
+	switch minutes {
+	case 0:
+		return "Never"
+	case 1:
+		return "1 minute idle"
+	default:
+		return fmt.Sprintf("%d minutes idle", minutes)
+	}
+}
@@ -0,0 +1,162 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"secure_vault/vault"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The changed function is called when the settings change, leave when the restore leaves the dashboard
+func showBackupsDialog(app fyne.App, window fyne.Window, guard *vaultGuard, v *vault.Vault, key []byte, vaultPath string, changed func(), leave func()) {
+	selectedBackup := -1
+	backups, err := vault.ListBackups(v, key)
+	if err != nil {
+		dialog.NewError(err, window).Show()
+		return
+	}
+
+	backupsList := widget.NewList(
+		func() int {
+			return len(backups)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewIcon(theme.ErrorIcon()),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			createdAt := backups[id].CreatedAt.Local().Format("2006-01-02 15:04:05")
+
+			// Set the icon for integrity status
+			if backups[id].Integrity {
+				obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(theme.ConfirmIcon())
+			} else {
+				obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(theme.CancelIcon())
+			}
+
+			// Set the CreatedAt value
+			obj.(*fyne.Container).Objects[1].(*widget.Label).SetText(createdAt)
+
+			// Set the size
+			obj.(*fyne.Container).Objects[2].(*widget.Label).SetText(formatSize(backups[id].Size))
+		},
+	)
+
+	backupsList.OnSelected = func(id widget.ListItemID) {
+		selectedBackup = id
+	}
+
+	backupsList.OnUnselected = func(id widget.ListItemID) {
+		selectedBackup = -1
+	}
+
+	// Backup settings
+	countEntry := widget.NewEntry()
+	countEntry.SetText(strconv.Itoa(v.Backups.Count))
+	folderEntry := widget.NewEntry()
+	folderEntry.SetPlaceHolder("Folder of the vault")
+	folderEntry.SetText(v.Backups.Folder)
+
+	browseButton := widget.NewButton("Browse", func() {
+		dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+			if uri != nil {
+				folderEntry.SetText(uri.Path())
+			}
+		}, window).Show()
+	})
+
+	settingsForm := widget.NewForm(
+		widget.NewFormItem("Generations Kept", countEntry),
+		widget.NewFormItem("Backup Folder", container.NewBorder(nil, nil, nil, browseButton, folderEntry)),
+	)
+
+	var backupsDialog dialog.Dialog
+
+	applyButton := widget.NewButton("Apply Settings", func() {
+		// Validation
+		count, err := strconv.Atoi(countEntry.Text)
+		if err != nil || count < 0 {
+			dialog.NewInformation("Error", "Generations kept must be a non-negative number.", window).Show()
+			return
+		}
+
+		if !guard.try() {
+			return
+		}
+		defer guard.unlock()
+
+		v.Backups = vault.BackupSettings{
+			Count:  count,
+			Folder: folderEntry.Text,
+		}
+
+		// List the generations in the new folder
+		backups, err = vault.ListBackups(v, key)
+		if err != nil {
+			dialog.NewError(err, window).Show()
+		}
+		backupsList.UnselectAll()
+		backupsList.Refresh()
+		changed()
+		dialog.NewInformation("Settings Changed", "Save the vault to apply the backup settings.", window).Show()
+	})
+
+	restoreButton := widget.NewButton("Restore Backup", func() {
+		if selectedBackup == -1 {
+			dialog.NewInformation("Error", "Please select a backup first.", window).Show()
+			return
+		}
+
+		backup := backups[selectedBackup]
+		message := fmt.Sprintf("Do you want to restore the vault from %s? Unsaved changes will be lost.",
+			backup.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		dialog.ShowConfirm("Question", message,
+			func(confirmed bool) {
+				if !confirmed || !guard.try() {
+					return
+				}
+				defer guard.unlock()
+
+				err := vault.RestoreBackup(v, key, backup.Path)
+				if err != nil {
+					dialog.NewError(err, window).Show()
+					return
+				}
+
+				// The restored vault may have other key slots, unlock it again
+				backupsDialog.Hide()
+				leave()
+				vault.LockVault(v, key)
+				ShowPasswordPage(app, window, vaultPath)
+				dialog.NewInformation("Backup Restored", "Unlock the restored vault "+filepath.Base(vaultPath)+".", window).Show()
+			}, window)
+	})
+
+	// Content for the top section
+	topContent := container.NewVBox(
+		settingsForm,
+		applyButton,
+	)
+
+	// Use Border layout to position elements
+	content := container.NewBorder(
+		topContent,
+		restoreButton,
+		nil,
+		nil,
+		backupsList,
+	)
+
+	backupsDialog = dialog.NewCustom("Backups", "Close", content, window)
+	backupsDialog.Resize(fyne.NewSize(500, 450))
+	backupsDialog.Show()
+}
@@ -0,0 +1,50 @@
+package ui
+
+import (
+	"fmt"
+	"secure_vault/vault"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Asks whether the unsaved changes are saved before going on. Without cancel,
+// the question comes from the auto-lock and the changes are saved if nobody
+// answers within the grace period.
+func showUnsavedChangesDialog(window fyne.Window, title string, changes vault.PendingChanges, cancelable bool,
+	proceed func(save bool), cancel func()) {
+	var changesDialog dialog.Dialog
+	var once sync.Once
+	choose := func(save bool, canceled bool) func() {
+		return func() {
+			once.Do(func() {
+				changesDialog.Hide()
+				if canceled {
+					cancel()
+				} else {
+					proceed(save)
+				}
+			})
+		}
+	}
+
+	buttons := container.NewHBox(
+		widget.NewButton("Save", choose(true, false)),
+		widget.NewButton("Discard", choose(false, false)),
+	)
+	message := "The vault has unsaved changes: " + changes.String() + ".\nSave them first?"
+	if cancelable {
+		buttons.Add(widget.NewButton("Cancel", choose(false, true)))
+	} else {
+		message = "The vault is locking after being idle, it has unsaved changes: " + changes.String() +
+			fmt.Sprintf(".\nUnanswered, the changes are saved in %.0f seconds.", autoLockSaveGracePeriod.Seconds())
+		time.AfterFunc(autoLockSaveGracePeriod, choose(true, false))
+	}
+
+	changesDialog = dialog.NewCustomWithoutButtons(title, container.NewVBox(widget.NewLabel(message), buttons), window)
+	changesDialog.Show()
+}
@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"path/filepath"
+	"secure_vault/vault"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Returns a conflict resolver asking the user with a dialog. It waits for the
+// answer, so the export must run outside the UI goroutine. The answer can be
+// applied to the remaining conflicts of the export. Files are skipped once the
+// dashboard is left.
+func newConflictResolver(window fyne.Window, guard *vaultGuard) func(filePath string) vault.ConflictPolicy {
+	var rememberedPolicy *vault.ConflictPolicy
+
+	return func(filePath string) vault.ConflictPolicy {
+		if rememberedPolicy != nil {
+			return *rememberedPolicy
+		}
+
+		choice := make(chan vault.ConflictPolicy, 1)
+		applyToAllCheck := widget.NewCheck("Apply to all conflicts", nil)
+
+		var conflictDialog dialog.Dialog
+		choose := func(policy vault.ConflictPolicy) func() {
+			return func() {
+				conflictDialog.Hide()
+				choice <- policy
+			}
+		}
+
+		content := container.NewVBox(
+			widget.NewLabel("\""+filepath.Base(filePath)+"\" already exists in "+filepath.Dir(filePath)+"."),
+			applyToAllCheck,
+			container.NewHBox(
+				widget.NewButton("Skip", choose(vault.ConflictSkip)),
+				widget.NewButton("Overwrite", choose(vault.ConflictOverwrite)),
+				widget.NewButton("Keep Both", choose(vault.ConflictRename)),
+			),
+		)
+		conflictDialog = dialog.NewCustomWithoutButtons("File Exists", content, window)
+		conflictDialog.Show()
+
+		var policy vault.ConflictPolicy
+		select {
+		case policy = <-choice:
+		case <-guard.left:
+			conflictDialog.Hide()
+			return vault.ConflictSkip
+		}
+		if applyToAllCheck.Checked {
+			rememberedPolicy = &policy
+		}
+		return policy
+	}
+}
+
+// Export options asking the user about every existing file
+func askingExportOptions(window fyne.Window, guard *vaultGuard) vault.ExportOptions {
+	return vault.ExportOptions{
+		Conflict: vault.ConflictAsk,
+		Resolve:  newConflictResolver(window, guard),
+	}
+}
@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"secure_vault/vault"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const rootFolderName = "/ (Root)"
+
+// Asks for a folder name and applies it with the given action
+func showFolderNameDialog(window fyne.Window, guard *vaultGuard, title string, confirm string, name string, apply func(name string) error, done func()) {
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(name)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Name", nameEntry),
+	}
+
+	dialog.ShowForm(title, confirm, "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		// Validation
+		if nameEntry.Text == "" {
+			dialog.NewInformation("Error", "Folder name is required.", window).Show()
+			return
+		}
+
+		if !guard.try() {
+			return
+		}
+		defer guard.unlock()
+
+		err := apply(nameEntry.Text)
+		if err != nil {
+			dialog.NewError(err, window).Show()
+		}
+		done()
+	}, window)
+}
+
+// Asks for the destination folder and moves the selection there with the given action
+func showMoveDialog(window fyne.Window, guard *vaultGuard, v *vault.Vault, move func(destination string) error, done func()) {
+	destinations := append([]string{rootFolderName}, v.Folders...)
+	destinationSelect := widget.NewSelect(destinations, nil)
+	destinationSelect.SetSelected(rootFolderName)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Destination", destinationSelect),
+	}
+
+	dialog.ShowForm("Move", "Move", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		destination := destinationSelect.Selected
+		if destination == rootFolderName {
+			destination = ""
+		}
+
+		if !guard.try() {
+			return
+		}
+		defer guard.unlock()
+
+		err := move(destination)
+		if err != nil {
+			dialog.NewError(err, window).Show()
+		}
+		done()
+	}, window)
+}
+
+func joinFolderPath(folderPath string, name string) string {
+	if folderPath == "" {
+		return name
+	}
+	return folderPath + "/" + name
+}
@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+)
+
+// Bytes of a file checked while the vault is held
+const integrityCheckPiece = 4 << 20
+
+// Result of the integrity check of a file
+type integrityStatus int
+
+const (
+	integrityPending integrityStatus = iota // Not checked yet
+	integrityValid
+	integrityInvalid
+)
+
+// Icon of the integrity status in the file list
+func (s integrityStatus) icon() fyne.Resource {
+	switch s {
+	case integrityValid:
+		return theme.ConfirmIcon()
+	case integrityInvalid:
+		return theme.CancelIcon()
+	default:
+		return theme.HistoryIcon()
+	}
+}
+
+// Checks the integrity of the listed files in a background goroutine and
+// keeps the results, so the list doesn't read the files again on every
+// render. The content of a file doesn't change while it keeps its ID.
+type integrityCache struct {
+	mutex   sync.Mutex
+	results map[string]integrityStatus
+	queue   []string
+	wake    chan struct{}
+	stopped bool
+	check   func(fileID string) bool // Checks a file, called from the goroutine
+	checked func()                   // Called from the goroutine after every check
+}
+
+func newIntegrityCache(check func(fileID string) bool, checked func()) *integrityCache {
+	c := &integrityCache{
+		results: make(map[string]integrityStatus),
+		wake:    make(chan struct{}, 1),
+		check:   check,
+		checked: checked,
+	}
+	go c.run()
+	return c
+}
+
+// Returns the result of the file, queuing its check the first time
+func (c *integrityCache) status(fileID string) integrityStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	status, ok := c.results[fileID]
+	if !ok && !c.stopped {
+		c.results[fileID] = integrityPending
+		c.queue = append(c.queue, fileID)
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return status
+}
+
+// Stops the goroutine, the queued files aren't checked
+func (c *integrityCache) stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.stopped {
+		c.stopped = true
+		c.queue = nil
+		close(c.wake)
+	}
+}
+
+// Reports whether the cache stopped, a running check can give up
+func (c *integrityCache) stopping() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stopped
+}
+
+func (c *integrityCache) run() {
+	for range c.wake {
+		for {
+			// Take the next queued file
+			c.mutex.Lock()
+			if c.stopped || len(c.queue) == 0 {
+				c.mutex.Unlock()
+				break
+			}
+			fileID := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mutex.Unlock()
+
+			status := integrityInvalid
+			if c.check(fileID) {
+				status = integrityValid
+			}
+
+			// Results of a check running while the cache stopped are dropped
+			c.mutex.Lock()
+			if c.stopped {
+				c.mutex.Unlock()
+				return
+			}
+			c.results[fileID] = status
+			c.mutex.Unlock()
+			c.checked()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package ui
+
+import (
+	"secure_vault/vault"
+	vaultUtils "secure_vault/vault/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+func showKeySlotsDialog(window fyne.Window, guard *vaultGuard, v *vault.Vault, key []byte, changed func()) {
+	selectedLabel := ""
+	keySlots := vault.ListKeySlots(v)
+
+	keySlotsList := widget.NewList(
+		func() int {
+			return len(keySlots)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			createdAt := keySlots[id].CreatedAt.Format("2006-01-02 15:04")
+
+			// Set the CreatedAt value
+			obj.(*fyne.Container).Objects[0].(*widget.Label).SetText(createdAt)
+
+			// Set the label
+			obj.(*fyne.Container).Objects[1].(*widget.Label).SetText(keySlots[id].Label)
+
+			// Set the required factors
+			obj.(*fyne.Container).Objects[2].(*widget.Label).SetText("(" + keySlots[id].Factors.String() + ")")
+		},
+	)
+
+	keySlotsList.OnSelected = func(id widget.ListItemID) {
+		selectedLabel = keySlots[id].Label
+	}
+
+	keySlotsList.OnUnselected = func(id widget.ListItemID) {
+		selectedLabel = ""
+	}
+
+	addKeySlotButton := widget.NewButton("Add Key Slot", func() {
+		labelEntry := widget.NewEntry()
+		passwordEntry := widget.NewPasswordEntry()
+		confirmPasswordEntry := widget.NewPasswordEntry()
+		keyfileEntry, keyfilePicker := newKeyfilePicker(window, true)
+
+		// Key derivation preset selection
+		var kdfPresetNames []string
+		for _, preset := range vaultUtils.KDFPresets {
+			kdfPresetNames = append(kdfPresetNames, preset.Name)
+		}
+		kdfSelect := widget.NewSelect(kdfPresetNames, nil)
+		kdfSelect.SetSelected(vaultUtils.KDFPresets[0].Name)
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Label", labelEntry),
+			widget.NewFormItem("Password", passwordEntry),
+			widget.NewFormItem("Confirm Password", confirmPasswordEntry),
+			widget.NewFormItem("Keyfile", keyfilePicker),
+			widget.NewFormItem("Key Derivation", kdfSelect),
+		}
+
+		dialog.ShowForm("Add Key Slot", "Add", "Cancel", items, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+
+			credentials := vault.Credentials{
+				Password:    passwordEntry.Text,
+				KeyfilePath: keyfileEntry.Text,
+			}
+
+			// Validation
+			if labelEntry.Text == "" || credentials.Factors() == 0 {
+				dialog.NewInformation("Error", "Label and a password or keyfile are required.", window).Show()
+				return
+			}
+			if passwordEntry.Text != confirmPasswordEntry.Text {
+				dialog.NewInformation("Error", "Passwords do not match.", window).Show()
+				return
+			}
+
+			kdfParams, err := vaultUtils.FindKDFPreset(kdfSelect.Selected)
+			if err != nil {
+				dialog.NewError(err, window).Show()
+				return
+			}
+
+			if !guard.try() {
+				return
+			}
+			defer guard.unlock()
+
+			err = vault.AddKeySlot(v, key, labelEntry.Text, credentials, kdfParams)
+			if err != nil {
+				dialog.NewError(err, window).Show()
+				return
+			}
+
+			keySlots = vault.ListKeySlots(v)
+			keySlotsList.UnselectAll()
+			keySlotsList.Refresh()
+			changed()
+			dialog.NewInformation("Key Slot Added", "Save the vault to apply the new key slot.", window).Show()
+		}, window)
+	})
+
+	revokeKeySlotButton := widget.NewButton("Revoke Key Slot", func() {
+		if selectedLabel == "" {
+			dialog.NewInformation("Error", "Please select a key slot first.", window).Show()
+			return
+		}
+
+		label := selectedLabel
+		dialog.ShowConfirm("Question", "Do you want to revoke the key slot \""+label+"\"?",
+			func(confirmed bool) {
+				if !confirmed || !guard.try() {
+					return
+				}
+				defer guard.unlock()
+
+				err := vault.RevokeKeySlot(v, label)
+				if err != nil {
+					dialog.NewError(err, window).Show()
+					return
+				}
+
+				keySlots = vault.ListKeySlots(v)
+				keySlotsList.UnselectAll()
+				keySlotsList.Refresh()
+				changed()
+				dialog.NewInformation("Key Slot Revoked", "Save the vault to apply the revocation.\nSaving deletes the backups, the revoked key slot still unlocks them.", window).Show()
+			}, window)
+	})
+
+	// Content for the bottom section
+	bottomContent := container.NewVBox(
+		addKeySlotButton,
+		revokeKeySlotButton,
+	)
+
+	// Use Border layout to position elements
+	content := container.NewBorder(
+		nil,
+		bottomContent,
+		nil,
+		nil,
+		keySlotsList,
+	)
+
+	keySlotsDialog := dialog.NewCustom("Key Slots", "Close", content, window)
+	keySlotsDialog.Resize(fyne.NewSize(500, 400))
+	keySlotsDialog.Show()
+}
@@ -0,0 +1,51 @@
+package ui
+
+import (
+	vaultUtils "secure_vault/vault/utils"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Returns an entry for the keyfile path with buttons to browse for a keyfile,
+// and to generate a new one if requested
+func newKeyfilePicker(window fyne.Window, withGenerate bool) (*widget.Entry, fyne.CanvasObject) {
+	keyfileEntry := widget.NewEntry()
+	keyfileEntry.SetPlaceHolder("Keyfile (optional)")
+
+	browseButton := widget.NewButton("Browse", func() {
+		dialog.NewFileOpen(func(uri fyne.URIReadCloser, err error) {
+			if uri != nil {
+				keyfileEntry.SetText(uri.URI().Path())
+				uri.Close()
+			}
+		}, window).Show()
+	})
+
+	buttons := container.NewHBox(browseButton)
+
+	if withGenerate {
+		generateButton := widget.NewButton("Generate", func() {
+			dialog.NewFileSave(func(uri fyne.URIWriteCloser, err error) {
+				if uri != nil {
+					keyfilePath := uri.URI().Path()
+					uri.Close()
+
+					err := vaultUtils.GenerateKeyfile(keyfilePath)
+					if err != nil {
+						dialog.NewError(err, window).Show()
+						return
+					}
+
+					keyfileEntry.SetText(keyfilePath)
+					dialog.NewInformation("Keyfile Generated", "Keep a backup of the keyfile, the vault can't be unlocked without it.", window).Show()
+				}
+			}, window).Show()
+		})
+		buttons.Add(generateButton)
+	}
+
+	return keyfileEntry, container.NewBorder(nil, nil, nil, buttons, keyfileEntry)
+}
@@ -1,10 +1,10 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"secure_vault/vault"
-	"secure_vault/vault/utils"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -16,43 +16,68 @@ func ShowPasswordPage(app fyne.App, window fyne.Window, vaultPath string) {
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetPlaceHolder("Enter password")
 
-	submitButton := widget.NewButton("Submit", func() {
-		password := passwordEntry.Text
+	keyfileEntry, keyfilePicker := newKeyfilePicker(window, false)
+
+	// Opening read-only never changes the vault
+	readOnlyCheck := widget.NewCheck("Open read-only", nil)
 
-		integrity, err := vault.CheckVaultIntegrity(vaultPath)
+	// Loads the vault with the given options and opens the dashboard
+	var unlock func(options vault.LoadOptions)
+	unlock = func(options vault.LoadOptions) {
+		credentials := vault.Credentials{
+			Password:    passwordEntry.Text,
+			KeyfilePath: keyfileEntry.Text,
+		}
+
+		// Load the vault and derive the encryption key
+		v, key, err := vault.LoadVault(credentials, vaultPath, options)
+		var inUseErr *vault.VaultInUseError
+		switch {
+		case errors.As(err, &inUseErr):
+			// Another process is writing the vault, it can still be read
+			dialog.ShowConfirm("Vault In Use", fmt.Sprintf("%v\n\nOpen it read-only?", err),
+				func(confirmed bool) {
+					if confirmed {
+						unlock(vault.LoadOptions{ReadOnly: true})
+					}
+				}, window)
+			return
+		case errors.Is(err, vault.ErrWrongPassword):
+			dialog.NewInformation("Wrong Credentials", "The password or keyfile doesn't unlock the vault.", window).Show()
+			return
+		case errors.Is(err, vault.ErrCorruptHeader), errors.Is(err, vault.ErrTruncated), errors.Is(err, vault.ErrIntegrityMismatch):
+			dialog.NewError(fmt.Errorf("the vault file is damaged: %w", err), window).Show()
+			return
+		case err != nil:
+			dialog.NewError(err, window).Show()
+			return
+		}
+
+		// Verify the vault integrity with the derived key
+		integrity, err := vault.CheckVaultIntegrity(vaultPath, key)
 		if err != nil {
-			dialog.NewError(err, window)
+			vault.LockVault(v, key)
+			dialog.NewError(err, window).Show()
 			return
 		}
 
 		if integrity {
-			v, err := vault.LoadVault(password, vaultPath)
-			if err != nil {
-				dialog.NewError(fmt.Errorf("%v: Typed password may be wrong", err), window).Show()
-				return
-			}
-
-			// Derive encryption key
-			key := utils.DeriveKey(password, v.Metadata.Salt)
 			ShowVaultDashboard(app, window, v, key, vaultPath)
-
 		} else {
 			dialog.ShowConfirm("Error", "Vault hash does not match. Do you wish to continue?",
 				func(confirmed bool) {
 					if confirmed {
 						// Proceed despite the error
-						v, err := vault.LoadVault(password, vaultPath)
-						if err != nil {
-							dialog.NewError(fmt.Errorf("%v: Typed password may be wrong", err), window).Show()
-							return
-						}
-
-						// Derive encryption key
-						key := utils.DeriveKey(password, v.Metadata.Salt)
 						ShowVaultDashboard(app, window, v, key, vaultPath)
+					} else {
+						vault.LockVault(v, key)
 					}
 				}, window)
 		}
+	}
+
+	submitButton := widget.NewButton("Submit", func() {
+		unlock(vault.LoadOptions{ReadOnly: readOnlyCheck.Checked})
 	})
 
 	backButton := widget.NewButton("Back", func() {
@@ -62,6 +87,8 @@ func ShowPasswordPage(app fyne.App, window fyne.Window, vaultPath string) {
 	// Content for the center section
 	centerContent := container.NewVBox(
 		passwordEntry,
+		keyfilePicker,
+		readOnlyCheck,
 	)
 
 	// Content for the bottom section
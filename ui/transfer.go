@@ -0,0 +1,154 @@
+package ui
+
+import (
+	"fmt"
+	"secure_vault/vault"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Asks for the patterns and the symbolic link policy, then runs the folder transfer
+func showTransferOptionsDialog(window fyne.Window, guard *vaultGuard, title string, confirm string, imports bool,
+	transfer func(options vault.TransferOptions) (*vault.TransferSummary, error), done func()) {
+	includeEntry := widget.NewEntry()
+	includeEntry.SetPlaceHolder("*.txt, docs/*")
+	excludeEntry := widget.NewEntry()
+	excludeEntry.SetPlaceHolder("*.tmp, .git")
+	followSymlinksCheck := widget.NewCheck("Follow symbolic links", nil)
+	attributes := map[string]vault.Attributes{
+		"Permissions":         vault.AttributeMode,
+		"Times":               vault.AttributeTimes,
+		"Owner":               vault.AttributeOwner,
+		"Extended attributes": vault.AttributeXattrs,
+	}
+	attributeNames := []string{"Permissions", "Times", "Owner", "Extended attributes"}
+	attributesGroup := widget.NewCheckGroup(attributeNames, nil)
+	if imports {
+		attributesGroup.SetSelected(attributeNames)
+	} else {
+		// Owners are only restored on request
+		attributesGroup.SetSelected([]string{"Permissions", "Times", "Extended attributes"})
+	}
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Include", includeEntry),
+		widget.NewFormItem("Exclude", excludeEntry),
+	}
+	if imports {
+		items = append(items, widget.NewFormItem("", followSymlinksCheck))
+	}
+	items = append(items, widget.NewFormItem("Keep", attributesGroup))
+
+	dialog.ShowForm(title, confirm, "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		options := vault.TransferOptions{
+			Include:  splitPatterns(includeEntry.Text),
+			Exclude:  splitPatterns(excludeEntry.Text),
+			Symlinks: vault.SymlinkSkip,
+		}
+		if followSymlinksCheck.Checked {
+			options.Symlinks = vault.SymlinkFollow
+		}
+
+		// Unchecked attributes are skipped
+		for _, name := range attributeNames {
+			if !containsString(attributesGroup.Selected, name) {
+				options.SkipAttributes |= attributes[name]
+			}
+		}
+		options.RestoreOwner = !imports && containsString(attributesGroup.Selected, "Owner")
+
+		runTransfer := func() {
+			summary, err := transfer(options)
+			if err != nil {
+				dialog.NewError(err, window).Show()
+			}
+			if summary != nil {
+				showTransferSummary(window, title, summary)
+			}
+			done()
+		}
+
+		// Exports run outside the UI goroutine, conflicts are resolved with a dialog
+		if !imports {
+			options.Conflict = vault.ConflictAsk
+			options.Resolve = newConflictResolver(window, guard)
+			go func() {
+				guard.lockTransfer()
+				defer guard.unlockTransfer()
+				runTransfer()
+			}()
+			return
+		}
+		if !guard.tryTransfer() {
+			return
+		}
+		defer guard.unlockTransfer()
+		runTransfer()
+	}, window)
+}
+
+// Shows the summary of a folder transfer with the files that weren't transferred
+func showTransferSummary(window fyne.Window, title string, summary *vault.TransferSummary) {
+	var lines []string
+	for _, result := range summary.Results {
+		switch {
+		case result.Err != nil:
+			lines = append(lines, fmt.Sprintf("Failed: %s (%v)", result.Path, result.Err))
+		case result.Skipped:
+			lines = append(lines, "Skipped: "+result.Path)
+		}
+	}
+
+	summaryLabel := widget.NewLabel(fmt.Sprintf("%d transferred (%s), %d skipped, %d failed",
+		summary.Transferred, formatSize(summary.Bytes), summary.Skipped, summary.Failed))
+	if len(lines) == 0 {
+		dialog.NewCustom(title, "Close", summaryLabel, window).Show()
+		return
+	}
+
+	resultsList := widget.NewList(
+		func() int {
+			return len(lines)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(lines[i])
+		},
+	)
+
+	content := container.NewBorder(summaryLabel, nil, nil, nil, resultsList)
+	resultsDialog := dialog.NewCustom(title, "Close", content, window)
+	resultsDialog.Resize(fyne.NewSize(500, 400))
+	resultsDialog.Show()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a comma separated list of patterns
+func splitPatterns(text string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(text, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -1,10 +1,13 @@
 package ui
 
 import (
+	"fmt"
 	"path/filepath"
 	uiUtils "secure_vault/ui/utils"
 	"secure_vault/vault"
 	vaultUtils "secure_vault/vault/utils"
+	"strconv"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -12,6 +15,11 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+const (
+	customKDFPreset   = "Custom"    // Name of the key derivation option with explicit values
+	calibrationTarget = time.Second // Target unlock time of the calibration
+)
+
 func ShowCreateVaultPage(app fyne.App, window fyne.Window, folderPath string) {
 	vaultNameEntry := widget.NewEntry()
 	vaultNameEntry.SetPlaceHolder("Enter vault name")
@@ -19,13 +27,75 @@ func ShowCreateVaultPage(app fyne.App, window fyne.Window, folderPath string) {
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetPlaceHolder("Enter password")
 
+	keyfileEntry, keyfilePicker := newKeyfilePicker(window, true)
+
+	// Cipher suite selection
+	var cipherNames []string
+	for _, suite := range vaultUtils.CipherSuites {
+		cipherNames = append(cipherNames, suite.String())
+	}
+	cipherSelect := widget.NewSelect(cipherNames, nil)
+	cipherSelect.SetSelected(vaultUtils.DefaultCipherSuite.String())
+
+	// Custom key derivation parameters
+	kdfTimeEntry := widget.NewEntry()
+	kdfTimeEntry.SetPlaceHolder("Iterations")
+	kdfMemoryEntry := widget.NewEntry()
+	kdfMemoryEntry.SetPlaceHolder("Memory (MiB)")
+	kdfThreadsEntry := widget.NewEntry()
+	kdfThreadsEntry.SetPlaceHolder("Threads")
+	kdfCustomContent := container.NewGridWithColumns(3, kdfTimeEntry, kdfMemoryEntry, kdfThreadsEntry)
+	kdfCustomContent.Hide()
+
+	// Key derivation preset selection
+	var kdfPresetNames []string
+	for _, preset := range vaultUtils.KDFPresets {
+		kdfPresetNames = append(kdfPresetNames, preset.Name)
+	}
+	kdfPresetNames = append(kdfPresetNames, customKDFPreset)
+	kdfSelect := widget.NewSelect(kdfPresetNames, func(selected string) {
+		if selected == customKDFPreset {
+			kdfCustomContent.Show()
+		} else {
+			kdfCustomContent.Hide()
+		}
+	})
+	kdfSelect.SetSelected(vaultUtils.KDFPresets[0].Name)
+
+	// Calibrate the iterations to the target unlock time on this machine
+	calibrateButton := widget.NewButton("Calibrate for 1 Second Unlock", func() {
+		memory, threads := vaultUtils.KDFPresets[0].Params.Memory, vaultUtils.KDFPresets[0].Params.Threads
+		if kdfSelect.Selected == customKDFPreset {
+			params, err := parseKDFParams("1", kdfMemoryEntry.Text, kdfThreadsEntry.Text)
+			if err != nil {
+				dialog.NewError(err, window).Show()
+				return
+			}
+			memory, threads = params.Memory, params.Threads
+		}
+
+		params, err := vaultUtils.CalibrateKDF(calibrationTarget, memory, threads)
+		if err != nil {
+			dialog.NewError(err, window).Show()
+			return
+		}
+
+		kdfTimeEntry.SetText(strconv.FormatUint(uint64(params.Time), 10))
+		kdfMemoryEntry.SetText(strconv.FormatUint(uint64(params.Memory/1024), 10))
+		kdfThreadsEntry.SetText(strconv.FormatUint(uint64(params.Threads), 10))
+		kdfSelect.SetSelected(customKDFPreset)
+	})
+
 	confirmButton := widget.NewButton("Create Vault", func() {
 		vaultName := vaultNameEntry.Text
-		password := passwordEntry.Text
+		credentials := vault.Credentials{
+			Password:    passwordEntry.Text,
+			KeyfilePath: keyfileEntry.Text,
+		}
 
 		// Validation
-		if vaultName == "" || password == "" {
-			dialog.NewInformation("Error", "Both vault name and password are required.", window).Show()
+		if vaultName == "" || credentials.Factors() == 0 {
+			dialog.NewInformation("Error", "Vault name and a password or keyfile are required.", window).Show()
 			return
 		}
 
@@ -38,20 +108,37 @@ func ShowCreateVaultPage(app fyne.App, window fyne.Window, folderPath string) {
 			}
 		}
 
+		// Get the selected cipher suite
+		cipherSuite, err := vaultUtils.ParseCipherSuite(cipherSelect.Selected)
+		if err != nil {
+			dialog.NewError(err, window).Show()
+			return
+		}
+
+		// Get the key derivation parameters
+		var kdfParams vaultUtils.KDFParams
+		if kdfSelect.Selected == customKDFPreset {
+			kdfParams, err = parseKDFParams(kdfTimeEntry.Text, kdfMemoryEntry.Text, kdfThreadsEntry.Text)
+		} else {
+			kdfParams, err = vaultUtils.FindKDFPreset(kdfSelect.Selected)
+		}
+		if err != nil {
+			dialog.NewError(err, window).Show()
+			return
+		}
+
 		// Full path for the new vault
 		vaultPath := filepath.Join(folderPath, vaultName+".vault")
 
 		// Create the vault
-		v, err := vault.CreateVault(password)
+		v, key, err := vault.CreateVault(credentials, cipherSuite, kdfParams)
 		if err != nil {
 			dialog.NewError(err, window).Show()
 			return
 		}
 
-		// Derive encryption key
-		key := vaultUtils.DeriveKey(password, v.Metadata.Salt)
-
 		err = vault.SaveVault(v, key, vaultPath)
+		vault.CloseVault(v)
 		if err != nil {
 			dialog.NewError(err, window).Show()
 			return
@@ -69,6 +156,11 @@ func ShowCreateVaultPage(app fyne.App, window fyne.Window, folderPath string) {
 	centerContent := container.NewVBox(
 		vaultNameEntry,
 		passwordEntry,
+		keyfilePicker,
+		cipherSelect,
+		kdfSelect,
+		kdfCustomContent,
+		calibrateButton,
 	)
 
 	// Content for the bottom section
@@ -88,3 +180,27 @@ func ShowCreateVaultPage(app fyne.App, window fyne.Window, folderPath string) {
 
 	window.SetContent(content)
 }
+
+func parseKDFParams(timeText, memoryText, threadsText string) (vaultUtils.KDFParams, error) {
+	kdfTime, err := strconv.ParseUint(timeText, 10, 32)
+	if err != nil {
+		return vaultUtils.KDFParams{}, fmt.Errorf("invalid iterations: %s", timeText)
+	}
+
+	memory, err := strconv.ParseUint(memoryText, 10, 22)
+	if err != nil {
+		return vaultUtils.KDFParams{}, fmt.Errorf("invalid memory: %s", memoryText)
+	}
+
+	threads, err := strconv.ParseUint(threadsText, 10, 8)
+	if err != nil {
+		return vaultUtils.KDFParams{}, fmt.Errorf("invalid threads: %s", threadsText)
+	}
+
+	params := vaultUtils.KDFParams{
+		Time:    uint32(kdfTime),
+		Memory:  uint32(memory * 1024),
+		Threads: uint8(threads),
+	}
+	return params, params.Validate()
+}
@@ -1,26 +1,79 @@
 package ui
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
 	"path/filepath"
 	"secure_vault/vault"
+	"strings"
+	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 func ShowVaultDashboard(app fyne.App, window fyne.Window, v *vault.Vault, key []byte, vaultPath string) {
-	selectedFileIndex := int64(-1)
-	vaultFiles := v.FilesMetadata
+	currentFolder := ""
+	var idleLock *autoLock
+	var integrity *integrityCache
 
-	vaultNameLabel := widget.NewLabel("Vault Name: " + filepath.Base(vaultPath))
+	// The vault is used by the UI and by the goroutines of the transfers and the timers
+	guard := newVaultGuard(window)
+
+	// The list shows what was read from the vault when the folder was listed.
+	// The mutex guards it and the selection, which the goroutines refresh too.
+	type fileRow struct {
+		id      string
+		name    string
+		addedAt string
+	}
+	var listMutex sync.Mutex
+	var subfolders []string
+	var files []fileRow
+	selectedFileID := ""
+	selectedFolder := ""
+	selection := func() (string, string) {
+		listMutex.Lock()
+		defer listMutex.Unlock()
+		return selectedFolder, selectedFileID
+	}
+
+	vaultName := filepath.Base(vaultPath)
+	if vault.IsReadOnly(v) {
+		vaultName += " (read-only)"
+	}
+	vaultNameLabel := widget.NewLabel("Vault Name: " + vaultName)
 	vaultCreatedAtLabel := widget.NewLabel("Vault Created At: " + v.Metadata.CreatedAt.Format("2006-01-02 15:04"))
+	deadSpaceLabel := widget.NewLabel("")
+	breadcrumb := container.NewHBox()
+
+	// The title shows an indicator while there are unsaved changes
+	title := window.Title()
+	refreshTitle := func() {
+		indicator := ""
+		if vault.IsDirty(v) {
+			indicator = " *"
+		}
+		window.SetTitle(title + " - " + vaultName + indicator)
+	}
+
+	refreshDeadSpace := func() {
+		deadSpaceLabel.SetText("Dead Space: " + formatSize(vault.DeadSpace(v)))
+		refreshTitle()
+	}
+	refreshDeadSpace()
 
 	filesList := widget.NewList(
 		func() int {
-			return len(vaultFiles)
+			listMutex.Lock()
+			defer listMutex.Unlock()
+			return len(subfolders) + len(files)
 		},
 		func() fyne.CanvasObject {
 			return container.NewHBox(
@@ -30,33 +83,151 @@ func ShowVaultDashboard(app fyne.App, window fyne.Window, v *vault.Vault, key []
 			)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
-			fileName := vaultFiles[id].Name
-			addedAt := vaultFiles[id].AddedAt.Format("2006-01-02 15:04")
-			fileIntegrity, _ := vault.CheckFileIntegrity(v, int64(id))
+			listMutex.Lock()
+			defer listMutex.Unlock()
 
-			// Set the icon for integrity status
-			if fileIntegrity {
-				obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(theme.ConfirmIcon())
-			} else {
-				obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(theme.CancelIcon())
+			// Folders are listed before the files
+			if id < len(subfolders) {
+				obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(theme.FolderIcon())
+				obj.(*fyne.Container).Objects[1].(*widget.Label).SetText("")
+				obj.(*fyne.Container).Objects[2].(*widget.Label).SetText(path.Base(subfolders[id]) + "/")
+				return
 			}
 
+			if id-len(subfolders) >= len(files) {
+				return
+			}
+			file := files[id-len(subfolders)]
+
+			// Set the icon for integrity status, pending until the file is checked
+			obj.(*fyne.Container).Objects[0].(*widget.Icon).SetResource(integrity.status(file.id).icon())
+
 			// Set the AddedAt value
-			obj.(*fyne.Container).Objects[1].(*widget.Label).SetText(addedAt)
+			obj.(*fyne.Container).Objects[1].(*widget.Label).SetText(file.addedAt)
 
 			// Set the file name
-			obj.(*fyne.Container).Objects[2].(*widget.Label).SetText(fileName)
+			obj.(*fyne.Container).Objects[2].(*widget.Label).SetText(file.name)
 		},
 	)
 
 	filesList.HideSeparators = true
 
+	// Files are checked in the background, once. The vault is only held for
+	// a piece of the file at a time, so large files don't keep the UI waiting.
+	integrity = newIntegrityCache(func(fileID string) bool {
+		check := &vault.FileCheck{FileID: fileID}
+		for !integrity.stopping() {
+			guard.lock()
+			done, valid, err := vault.ContinueFileCheck(v, key, check, integrityCheckPiece)
+			guard.unlock()
+			if err != nil || done {
+				return err == nil && valid
+			}
+		}
+		return false
+	}, filesList.Refresh)
+
 	filesList.OnSelected = func(id widget.ListItemID) {
-		selectedFileIndex = vaultFiles[id].Index
+		idleLock.touch()
+		listMutex.Lock()
+		defer listMutex.Unlock()
+		if id < len(subfolders) {
+			selectedFolder = subfolders[id]
+			selectedFileID = ""
+		} else if id-len(subfolders) < len(files) {
+			selectedFolder = ""
+			selectedFileID = files[id-len(subfolders)].id
+		}
 	}
 
 	filesList.OnUnselected = func(id widget.ListItemID) {
-		selectedFileIndex = -1
+		idleLock.touch()
+		listMutex.Lock()
+		defer listMutex.Unlock()
+		selectedFolder = ""
+		selectedFileID = ""
+	}
+
+	// Shows the content of the folder and the path leading to it, with the vault held
+	var openFolder func(folderPath string)
+	openFolder = func(folderPath string) {
+		folderSubfolders, fileIDs, err := vault.ListFolder(v, folderPath)
+		if err != nil {
+			// The folder is gone, go back to the root
+			folderPath = ""
+			folderSubfolders, fileIDs, _ = vault.ListFolder(v, folderPath)
+		}
+		currentFolder = folderPath
+
+		// Read the rows of the files
+		var folderFiles []fileRow
+		for _, fileID := range fileIDs {
+			fileMetadata, err := vault.FileByID(v, fileID)
+			if err != nil {
+				continue
+			}
+			folderFiles = append(folderFiles, fileRow{
+				id:      fileID,
+				name:    fileMetadata.Name,
+				addedAt: fileMetadata.AddedAt.Format("2006-01-02 15:04"),
+			})
+		}
+		listMutex.Lock()
+		subfolders = folderSubfolders
+		files = folderFiles
+		listMutex.Unlock()
+
+		// Rebuild the breadcrumb
+		openCrumb := func(crumbPath string) func() {
+			return func() {
+				idleLock.touch()
+				if !guard.try() {
+					return
+				}
+				defer guard.unlock()
+				openFolder(crumbPath)
+			}
+		}
+		breadcrumb.Objects = nil
+		breadcrumb.Add(widget.NewButton("Root", openCrumb("")))
+		if folderPath != "" {
+			elements := strings.Split(folderPath, "/")
+			for i, element := range elements {
+				breadcrumb.Add(widget.NewLabel(">"))
+				breadcrumb.Add(widget.NewButton(element, openCrumb(strings.Join(elements[:i+1], "/"))))
+			}
+		}
+		breadcrumb.Refresh()
+
+		filesList.UnselectAll()
+		filesList.Refresh()
+		refreshTitle()
+	}
+
+	refreshFiles := func() {
+		openFolder(currentFolder)
+	}
+	refreshFiles()
+
+	// Originals of the added files by file ID, deleted once the vault is saved
+	pendingDeletes := make(map[string]string)
+	saveVault := func() error {
+		err := vault.SaveVault(v, key, vaultPath)
+		if err != nil {
+			return err
+		}
+
+		// Files removed from the vault before the save keep their original
+		for fileID, filePath := range pendingDeletes {
+			if _, err := vault.FileByID(v, fileID); err == nil {
+				err = os.Remove(filePath)
+				if err != nil {
+					dialog.NewError(err, window).Show()
+				}
+			}
+		}
+		clear(pendingDeletes)
+		return nil
 	}
 
 	addFileButton := widget.NewButton("Add File", func() {
@@ -66,42 +237,76 @@ func ShowVaultDashboard(app fyne.App, window fyne.Window, v *vault.Vault, key []
 				uri.Close()
 
 				// Ask user for file deletion
-				dialog.ShowConfirm("Question", "Do you want to delete the original file?",
+				dialog.ShowConfirm("Question", "Do you want to delete the original file once the vault is saved?",
 					func(confirmed bool) {
-						var err error
-						if confirmed {
-							err = vault.AddFileToVault(v, key, filePath, true)
-						} else {
-							err = vault.AddFileToVault(v, key, filePath, false)
+						if !guard.try() {
+							return
+						}
+						defer guard.unlock()
+
+						err := vault.AddFileToVault(v, key, filePath, currentFolder, false, 0)
+						if err == nil && confirmed {
+							var fileMetadata vault.FileMetadata
+							fileMetadata, err = vault.FileByPath(v, joinFolderPath(currentFolder, filepath.Base(filePath)))
+							if err == nil {
+								pendingDeletes[fileMetadata.ID] = filePath
+							}
 						}
 
 						if err != nil {
 							dialog.NewError(err, window).Show()
 						}
 
-						vaultFiles = v.FilesMetadata
-						filesList.Refresh()
+						refreshFiles()
 					}, window)
 			}
 			filesList.UnselectAll()
 		}, window).Show()
 	})
 
-	extractFileButton := widget.NewButton("Extract File", func() {
-		if selectedFileIndex != -1 {
+	exportFileButton := widget.NewButton("Export File", func() {
+		if _, fileID := selection(); fileID != "" {
 			dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
 				if uri != nil {
 					folderPath := uri.Path()
 
-					err := vault.ExtractFileFromVault(v, key, selectedFileIndex, folderPath)
-					if err != nil {
-						dialog.NewError(err, window).Show()
-					}
+					// Export outside the UI goroutine, conflicts are resolved with a dialog
+					go func() {
+						guard.lockTransfer()
+						defer guard.unlockTransfer()
 
-					vaultFiles = v.FilesMetadata
-					filesList.Refresh()
+						_, err := vault.ExportFile(v, key, fileID, folderPath, askingExportOptions(window, guard))
+						if err != nil {
+							dialog.NewError(err, window).Show()
+						}
+					}()
+				}
+			}, window).Show()
+		} else {
+			dialog.NewInformation("Error", "Please select a file first.", window).Show()
+		}
+	})
+
+	takeFileButton := widget.NewButton("Take File", func() {
+		if _, fileID := selection(); fileID != "" {
+			dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+				if uri != nil {
+					folderPath := uri.Path()
+
+					// Take outside the UI goroutine, conflicts are resolved with a dialog
+					go func() {
+						guard.lockTransfer()
+						defer guard.unlockTransfer()
+
+						// The file is removed once it is written out, a skipped file stays
+						_, err := vault.TakeFile(v, key, fileID, folderPath, askingExportOptions(window, guard))
+						if err != nil {
+							dialog.NewError(err, window).Show()
+						}
+						refreshDeadSpace()
+						refreshFiles()
+					}()
 				}
-				filesList.UnselectAll()
 			}, window).Show()
 		} else {
 			dialog.NewInformation("Error", "Please select a file first.", window).Show()
@@ -109,53 +314,391 @@ func ShowVaultDashboard(app fyne.App, window fyne.Window, v *vault.Vault, key []
 	})
 
 	removeFileButton := widget.NewButton("Remove File", func() {
-		if selectedFileIndex != -1 {
-			err := vault.RemoveFileFromVault(v, selectedFileIndex)
+		if _, fileID := selection(); fileID != "" {
+			err := vault.RemoveFileFromVault(v, fileID)
 			if err != nil {
 				dialog.NewError(err, window).Show()
 			}
-			vaultFiles = v.FilesMetadata
-			filesList.UnselectAll()
-			filesList.Refresh()
+			refreshDeadSpace()
+			refreshFiles()
 		} else {
 			dialog.NewInformation("Error", "Please select a file first.", window).Show()
 		}
 	})
 
+	openFolderButton := widget.NewButton("Open Folder", func() {
+		if folderPath, _ := selection(); folderPath != "" {
+			openFolder(folderPath)
+		} else {
+			dialog.NewInformation("Error", "Please select a folder first.", window).Show()
+		}
+	})
+
+	newFolderButton := widget.NewButton("New Folder", func() {
+		showFolderNameDialog(window, guard, "New Folder", "Create", "", func(name string) error {
+			return vault.CreateFolder(v, joinFolderPath(currentFolder, name))
+		}, refreshFiles)
+	})
+
+	importFolderButton := widget.NewButton("Import Folder", func() {
+		dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+			if uri != nil {
+				dirPath := uri.Path()
+				folderPath := currentFolder
+				showTransferOptionsDialog(window, guard, "Import Folder", "Import", true,
+					func(options vault.TransferOptions) (*vault.TransferSummary, error) {
+						return vault.ImportFolder(v, key, dirPath, folderPath, options)
+					}, refreshFiles)
+			}
+		}, window).Show()
+	})
+
+	exportFolderButton := widget.NewButton("Export Folder", func() {
+		// Export the selected folder, or the open one
+		folderPath, _ := selection()
+		if folderPath == "" {
+			folderPath = currentFolder
+		}
+
+		dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+			if uri != nil {
+				exportFolderPath := uri.Path()
+				showTransferOptionsDialog(window, guard, "Export Folder", "Export", false,
+					func(options vault.TransferOptions) (*vault.TransferSummary, error) {
+						return vault.ExportFolder(v, key, folderPath, exportFolderPath, options)
+					}, func() {})
+			}
+		}, window).Show()
+	})
+
+	renameFolderButton := widget.NewButton("Rename Folder", func() {
+		folderPath, _ := selection()
+		if folderPath == "" {
+			dialog.NewInformation("Error", "Please select a folder first.", window).Show()
+			return
+		}
+
+		showFolderNameDialog(window, guard, "Rename Folder", "Rename", path.Base(folderPath), func(name string) error {
+			return vault.RenameFolder(v, folderPath, name)
+		}, refreshFiles)
+	})
+
+	moveButton := widget.NewButton("Move", func() {
+		folderPath, fileID := selection()
+		switch {
+		case folderPath != "":
+			showMoveDialog(window, guard, v, func(destination string) error {
+				return vault.MoveFolder(v, folderPath, destination)
+			}, refreshFiles)
+		case fileID != "":
+			showMoveDialog(window, guard, v, func(destination string) error {
+				return vault.MoveFile(v, fileID, destination)
+			}, refreshFiles)
+		default:
+			dialog.NewInformation("Error", "Please select a file or a folder first.", window).Show()
+		}
+	})
+
+	deleteFolderButton := widget.NewButton("Delete Folder", func() {
+		folderPath, _ := selection()
+		if folderPath == "" {
+			dialog.NewInformation("Error", "Please select a folder first.", window).Show()
+			return
+		}
+
+		dialog.ShowConfirm("Question", "Do you want to delete the folder \""+folderPath+"\" with everything in it?",
+			func(confirmed bool) {
+				if !confirmed || !guard.try() {
+					return
+				}
+				defer guard.unlock()
+
+				err := vault.DeleteFolder(v, folderPath, true)
+				if err != nil {
+					dialog.NewError(err, window).Show()
+				}
+				refreshDeadSpace()
+				refreshFiles()
+			}, window)
+	})
+
 	saveVaultButton := widget.NewButton("Save Vault", func() {
-		err := vault.SaveVault(v, key, vaultPath)
+		err := saveVault()
 		if err != nil {
 			dialog.NewError(err, window).Show()
 		}
+		refreshDeadSpace()
+	})
+
+	compactVaultButton := widget.NewButton("Compact Vault", func() {
+		dialog.ShowConfirm("Compact Vault", "Rewrite the saved vault without the dead space?",
+			func(confirmed bool) {
+				if !confirmed || !guard.try() {
+					return
+				}
+				defer guard.unlock()
+
+				err := vault.CompactVault(v, key)
+				if err != nil {
+					dialog.NewError(err, window).Show()
+				}
+				refreshDeadSpace()
+			}, window)
+	})
+
+	changePasswordButton := widget.NewButton("Change Password", func() {
+		oldPasswordEntry := widget.NewPasswordEntry()
+		newPasswordEntry := widget.NewPasswordEntry()
+		confirmPasswordEntry := widget.NewPasswordEntry()
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Current Password", oldPasswordEntry),
+			widget.NewFormItem("New Password", newPasswordEntry),
+			widget.NewFormItem("Confirm Password", confirmPasswordEntry),
+		}
+
+		dialog.ShowForm("Change Password", "Change", "Cancel", items, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+
+			// Validation
+			if newPasswordEntry.Text == "" {
+				dialog.NewInformation("Error", "New password is required.", window).Show()
+				return
+			}
+			if newPasswordEntry.Text != confirmPasswordEntry.Text {
+				dialog.NewInformation("Error", "New passwords do not match.", window).Show()
+				return
+			}
+
+			if !guard.try() {
+				return
+			}
+			defer guard.unlock()
+
+			err := vault.ChangePassword(v, oldPasswordEntry.Text, newPasswordEntry.Text)
+			if errors.Is(err, vault.ErrWrongPassword) {
+				dialog.NewInformation("Error", "Current password is wrong.", window).Show()
+				return
+			}
+			if err != nil {
+				dialog.NewError(err, window).Show()
+				return
+			}
+
+			refreshTitle()
+			dialog.NewInformation("Password Changed", "Save the vault to apply the new password.\nSaving deletes the backups, the old password still unlocks them.", window).Show()
+		}, window)
+	})
+
+	keySlotsButton := widget.NewButton("Key Slots", func() {
+		showKeySlotsDialog(window, guard, v, key, refreshTitle)
+	})
+
+	// Leaving the dashboard asks about the unsaved changes, then wipes the key
+	var content fyne.CanvasObject
+	leaveDashboard := func() {
+		idleLock.stop()
+		integrity.stop()
+		guard.leave()
+		window.Canvas().RemoveShortcut(lockShortcut)
+		window.Canvas().SetOnTypedKey(nil)
+		window.Canvas().SetOnTypedRune(nil)
+		if desktopCanvas, ok := window.Canvas().(desktop.Canvas); ok {
+			desktopCanvas.SetOnKeyDown(nil)
+		}
+		window.SetCloseIntercept(nil)
+		window.SetTitle(title)
+	}
+	leave := func(save bool, next func()) {
+		if save {
+			err := saveVault()
+			if err != nil {
+				dialog.NewError(err, window).Show()
+				refreshTitle()
+				idleLock.setTimeout(autoLockTimeout(app))
+				return
+			}
+		}
+
+		leaveDashboard()
+		vault.LockVault(v, key)
+		next()
+	}
+	confirmLeave := func(title string, cancelable bool, next func()) {
+		// The dashboard may have been left another way
+		if window.Content() != content {
+			leaveDashboard()
+			return
+		}
+
+		changes := vault.Pending(v)
+		if changes.Empty() {
+			leave(false, next)
+			return
+		}
+
+		// No second question while this one is open
+		idleLock.stop()
+		showUnsavedChangesDialog(window, title, changes, cancelable, func(save bool) {
+			if !guard.try() {
+				return
+			}
+			defer guard.unlock()
+			leave(save, next)
+		}, func() {
+			idleLock.setTimeout(autoLockTimeout(app))
+		})
+	}
+
+	// Lock the vault on demand, with the shortcut, or after the idle timeout
+	showPasswordPage := func() {
+		for _, overlay := range window.Canvas().Overlays().List() {
+			window.Canvas().Overlays().Remove(overlay)
+		}
+		ShowPasswordPage(app, window, vaultPath)
+	}
+	guardedLeave := func(title string, cancelable bool, next func()) {
+		if !guard.try() {
+			return
+		}
+		defer guard.unlock()
+		confirmLeave(title, cancelable, next)
+	}
+	idleLock = newAutoLock(autoLockTimeout(app), func() {
+		// A transfer that started meanwhile restarts the timeout when it ends
+		if guard.transferring() {
+			return
+		}
+		guardedLeave("Lock Vault", false, showPasswordPage)
+	})
+	guard.idleLock = idleLock
+	window.Canvas().AddShortcut(lockShortcut, func(fyne.Shortcut) {
+		guardedLeave("Lock Vault", true, showPasswordPage)
+	})
+
+	// Keyboard input counts as activity
+	window.Canvas().SetOnTypedKey(func(*fyne.KeyEvent) {
+		idleLock.touch()
+	})
+	window.Canvas().SetOnTypedRune(func(rune) {
+		idleLock.touch()
+	})
+	if desktopCanvas, ok := window.Canvas().(desktop.Canvas); ok {
+		desktopCanvas.SetOnKeyDown(func(*fyne.KeyEvent) {
+			idleLock.touch()
+		})
+	}
+
+	// Quitting with unsaved changes asks first
+	window.SetCloseIntercept(func() {
+		guardedLeave("Quit", true, window.Close)
+	})
+
+	backupsButton := widget.NewButton("Backups", func() {
+		showBackupsDialog(app, window, guard, v, key, vaultPath, refreshTitle, leaveDashboard)
+	})
+
+	lockButton := widget.NewButton("Lock Now", func() {
+		confirmLeave("Lock Vault", true, showPasswordPage)
+	})
+
+	autoLockButton := widget.NewButton("Auto-Lock", func() {
+		showAutoLockDialog(app, window, idleLock.setTimeout)
 	})
 
 	backButton := widget.NewButton("Close Vault", func() {
-		ShowSelectVaultPage(app, window, filepath.Dir(vaultPath))
+		confirmLeave("Close Vault", true, func() {
+			ShowSelectVaultPage(app, window, filepath.Dir(vaultPath))
+		})
 	})
 
+	// Read-only vaults can only be browsed and exported
+	if vault.IsReadOnly(v) {
+		for _, button := range []*widget.Button{
+			addFileButton,
+			takeFileButton,
+			removeFileButton,
+			newFolderButton,
+			importFolderButton,
+			renameFolderButton,
+			moveButton,
+			deleteFolderButton,
+			saveVaultButton,
+			compactVaultButton,
+			changePasswordButton,
+			keySlotsButton,
+			backupsButton,
+		} {
+			button.Disable()
+		}
+	}
+
 	// Content for the top section
 	topContent := container.NewVBox(
 		vaultNameLabel,
 		vaultCreatedAtLabel,
+		deadSpaceLabel,
+		breadcrumb,
+	)
+
+	// Content for the right section
+	rightContent := container.NewVBox(
+		openFolderButton,
+		newFolderButton,
+		importFolderButton,
+		exportFolderButton,
+		renameFolderButton,
+		moveButton,
+		deleteFolderButton,
+		widget.NewSeparator(),
+		lockButton,
+		autoLockButton,
 	)
 
 	// Content for the bottom section
 	bottomContent := container.NewVBox(
 		addFileButton,
-		extractFileButton,
+		exportFileButton,
+		takeFileButton,
 		removeFileButton,
 		saveVaultButton,
+		compactVaultButton,
+		changePasswordButton,
+		keySlotsButton,
+		backupsButton,
 		backButton,
 	)
 
+	// Any tapped button counts as activity, and uses the vault
+	guard.watchButtons(rightContent.Objects)
+	guard.watchButtons(bottomContent.Objects)
+	idleLock.watchButtons(rightContent.Objects)
+	idleLock.watchButtons(bottomContent.Objects)
+
 	// Use Border layout to position elements
-	content := container.NewBorder(
+	content = container.NewBorder(
 		topContent,
 		bottomContent,
 		nil,
-		nil,
+		rightContent,
 		filesList,
 	)
 
 	window.SetContent(content)
 }
+
+// Formats a size in bytes with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
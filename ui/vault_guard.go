@@ -0,0 +1,121 @@
+package ui
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Serializes the use of the open vault between the UI and the goroutines of
+// the transfers, the integrity checks and the timers. A transfer holds the
+// vault until it ends, and may wait for an answer of the UI meanwhile, so the
+// UI never waits for a transfer: it tells the user to wait instead.
+type vaultGuard struct {
+	mutex     sync.Mutex    // Held while the vault is used
+	waiting   atomic.Int32  // UI callbacks waiting for the vault, which go before the goroutines
+	state     sync.Mutex    // Guards transfers
+	transfers int           // Transfers holding or waiting for the vault
+	idleLock  *autoLock     // Suspended while a transfer runs, if set
+	left      chan struct{} // Closed once the dashboard is left
+	leaveOnce sync.Once
+	window    fyne.Window
+}
+
+func newVaultGuard(window fyne.Window) *vaultGuard {
+	return &vaultGuard{window: window, left: make(chan struct{})}
+}
+
+// Takes the vault for the UI, false with a message if a transfer holds it.
+// Other holders only hold it for a moment, the UI waits for them.
+func (g *vaultGuard) try() bool {
+	g.waiting.Add(1)
+	defer g.waiting.Add(-1)
+	for !g.mutex.TryLock() {
+		if g.transferring() {
+			dialog.NewInformation("Transfer Running", "Please wait for the transfer to finish.", g.window).Show()
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Waits for the vault and the UI callbacks waiting for it, never from the UI
+func (g *vaultGuard) lock() {
+	for g.waiting.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	g.mutex.Lock()
+}
+
+func (g *vaultGuard) unlock() {
+	g.mutex.Unlock()
+}
+
+// Waits for the vault and holds it for a transfer, never from the UI
+func (g *vaultGuard) lockTransfer() {
+	g.startTransfer()
+	g.mutex.Lock()
+}
+
+// Takes the vault for a transfer running in the UI goroutine, like try
+func (g *vaultGuard) tryTransfer() bool {
+	if !g.try() {
+		return false
+	}
+	g.startTransfer()
+	return true
+}
+
+func (g *vaultGuard) unlockTransfer() {
+	g.state.Lock()
+	g.transfers--
+	if g.idleLock != nil {
+		g.idleLock.release()
+	}
+	g.state.Unlock()
+	g.mutex.Unlock()
+}
+
+func (g *vaultGuard) startTransfer() {
+	g.state.Lock()
+	defer g.state.Unlock()
+
+	g.transfers++
+	if g.idleLock != nil {
+		g.idleLock.hold()
+	}
+}
+
+func (g *vaultGuard) transferring() bool {
+	g.state.Lock()
+	defer g.state.Unlock()
+	return g.transfers > 0
+}
+
+// Tells the transfers waiting for an answer of the UI that the dashboard is gone
+func (g *vaultGuard) leave() {
+	g.leaveOnce.Do(func() {
+		close(g.left)
+	})
+}
+
+// Takes the vault for the UI whenever one of the buttons is tapped
+func (g *vaultGuard) watchButtons(objects []fyne.CanvasObject) {
+	for _, object := range objects {
+		if button, ok := object.(*widget.Button); ok {
+			onTapped := button.OnTapped
+			button.OnTapped = func() {
+				if !g.try() {
+					return
+				}
+				defer g.unlock()
+				onTapped()
+			}
+		}
+	}
+}
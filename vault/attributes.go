@@ -0,0 +1,117 @@
+package vault
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Attributes is a set of file attributes kept besides the content
+type Attributes uint8
+
+const (
+	AttributeMode   Attributes = 1 << iota // Permission bits
+	AttributeTimes                         // Modification and access times
+	AttributeOwner                         // User and group IDs
+	AttributeXattrs                        // Extended attributes of the user namespace, Linux only
+)
+
+// Permission bits restored on the extracted files
+const attributeModeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// FileAttributes are the attributes of the original file, recorded when it is added
+type FileAttributes struct {
+	Recorded   Attributes        // Attributes recorded for the file, files added before attributes have none
+	Mode       fs.FileMode       // Permission bits
+	ModTime    time.Time         // Modification time
+	AccessTime time.Time         // Access time, the modification time where the platform doesn't record it
+	UID        int               // Owner user ID
+	GID        int               // Owner group ID
+	Xattrs     map[string][]byte // Extended attributes by name
+}
+
+func (a Attributes) String() string {
+	names := []string{"mode", "times", "owner", "xattrs"}
+	s := ""
+	for i, name := range names {
+		if a&(1<<i) == 0 {
+			continue
+		}
+		if s != "" {
+			s += ", "
+		}
+		s += name
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// Records the attributes of the file on the disk, except the skipped ones
+func readFileAttributes(filePath string, stat fs.FileInfo, skip Attributes) (FileAttributes, error) {
+	attributes := FileAttributes{}
+
+	if skip&AttributeMode == 0 {
+		attributes.Mode = stat.Mode() & attributeModeMask
+		attributes.Recorded |= AttributeMode
+	}
+
+	if skip&AttributeTimes == 0 {
+		attributes.ModTime = stat.ModTime()
+		attributes.AccessTime = stat.ModTime()
+		attributes.Recorded |= AttributeTimes
+	}
+
+	// Access time, owner and extended attributes depend on the platform
+	err := readPlatformAttributes(filePath, stat, skip, &attributes)
+	if err != nil {
+		return FileAttributes{}, err
+	}
+
+	return attributes, nil
+}
+
+// ApplyFileAttributes restores the recorded attributes of the file on the
+// extracted copy, except the skipped ones. Owner and extended attributes
+// the process isn't allowed to set are left as they are. The setuid and
+// setgid bits are dropped unless the file was given its recorded owner, and
+// always when extracting as root.
+func ApplyFileAttributes(fileMetadata FileMetadata, filePath string, skip Attributes) error {
+	attributes := fileMetadata.Attributes
+	restore := attributes.Recorded &^ skip
+
+	// Owner and extended attributes first, changing the owner can clear the setuid bits
+	ownerRestored, err := writePlatformAttributes(filePath, attributes, restore)
+	if err != nil {
+		return err
+	}
+
+	if restore&AttributeMode != 0 {
+		// A vault could otherwise plant a program running as the extracting user
+		mode := attributes.Mode
+		if !ownerRestored || os.Geteuid() == 0 {
+			mode &^= fs.ModeSetuid | fs.ModeSetgid
+		}
+		err = os.Chmod(filePath, mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Times last, the other changes don't touch them
+	if restore&AttributeTimes != 0 {
+		err = os.Chtimes(filePath, attributes.AccessTime, attributes.ModTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the error only means the process lacks the privilege
+func isPermissionError(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
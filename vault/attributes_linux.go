@@ -0,0 +1,147 @@
+//go:build linux
+
+package vault
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Only the extended attributes of this namespace are recorded and restored.
+// The security, trusted and system ones grant capabilities or carry ACLs and
+// labels, which a crafted vault could otherwise plant in the extracted files.
+const xattrNamespace = "user."
+
+// Reads the access time, the owner and the extended attributes
+func readPlatformAttributes(filePath string, stat fs.FileInfo, skip Attributes, attributes *FileAttributes) error {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		if skip&AttributeTimes == 0 {
+			attributes.AccessTime = time.Unix(sys.Atim.Unix())
+		}
+		if skip&AttributeOwner == 0 {
+			attributes.UID = int(sys.Uid)
+			attributes.GID = int(sys.Gid)
+			attributes.Recorded |= AttributeOwner
+		}
+	}
+
+	if skip&AttributeXattrs == 0 {
+		xattrs, err := readXattrs(filePath)
+		if err != nil {
+			return err
+		}
+		attributes.Xattrs = xattrs
+		attributes.Recorded |= AttributeXattrs
+	}
+
+	return nil
+}
+
+// Restores the owner and the extended attributes, reports whether the owner
+// was changed to the recorded one
+func writePlatformAttributes(filePath string, attributes FileAttributes, restore Attributes) (bool, error) {
+	if restore&AttributeXattrs != 0 {
+		for name, value := range attributes.Xattrs {
+			if !strings.HasPrefix(name, xattrNamespace) {
+				continue
+			}
+			err := syscall.Setxattr(filePath, name, value, 0)
+			if err != nil && !isPermissionError(err) && !errors.Is(err, syscall.ENOTSUP) {
+				return false, &os.PathError{Op: "setxattr", Path: filePath, Err: err}
+			}
+		}
+	}
+
+	if restore&AttributeOwner == 0 {
+		return false, nil
+	}
+	err := os.Chown(filePath, attributes.UID, attributes.GID)
+	if isPermissionError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func readXattrs(filePath string) (map[string][]byte, error) {
+	// List the names, file systems without extended attributes have none
+	names, err := listXattrs(filePath)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: filePath, Err: err}
+	}
+
+	xattrs := map[string][]byte{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, xattrNamespace) {
+			continue
+		}
+		value, err := getXattr(filePath, name)
+		if errors.Is(err, syscall.ENODATA) || isPermissionError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: filePath, Err: err}
+		}
+		xattrs[name] = value
+	}
+
+	return xattrs, nil
+}
+
+func listXattrs(filePath string) ([]string, error) {
+	// Ask for the size first, the list may grow in between
+	for {
+		size, err := syscall.Listxattr(filePath, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buffer := make([]byte, size)
+		size, err = syscall.Listxattr(filePath, buffer)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Names are NUL terminated
+		var names []string
+		start := 0
+		for i, b := range buffer[:size] {
+			if b == 0 {
+				names = append(names, string(buffer[start:i]))
+				start = i + 1
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(filePath string, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(filePath, name, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		size, err = syscall.Getxattr(filePath, name, value)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return value[:size], nil
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Creates a file with the extended attributes, skipping the test where they can't be set
+func createXattrFile(t *testing.T, xattrs map[string]string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(filePath, []byte("content"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range xattrs {
+		err = syscall.Setxattr(filePath, name, []byte(value), 0)
+		if errors.Is(err, syscall.ENOTSUP) || isPermissionError(err) {
+			t.Skipf("can't set %s here: %v", name, err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filePath
+}
+
+func TestXattrsOnlyUserNamespace(t *testing.T) {
+	filePath := createXattrFile(t, map[string]string{"user.kept": "kept", "trusted.dropped": "dropped"})
+
+	// Only the user namespace is recorded
+	xattrs, err := readXattrs(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xattrs) != 1 || string(xattrs["user.kept"]) != "kept" {
+		t.Errorf("got recorded attributes %q, want only user.kept", xattrs)
+	}
+
+	// Nor is anything else restored from a crafted vault
+	outputPath := createXattrFile(t, nil)
+	fileMetadata := FileMetadata{Attributes: FileAttributes{
+		Recorded: AttributeXattrs,
+		Xattrs: map[string][]byte{
+			"user.kept":           []byte("kept"),
+			"trusted.dropped":     []byte("dropped"),
+			"security.capability": {1, 0, 0, 2, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+	}}
+	err = ApplyFileAttributes(fileMetadata, outputPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err = readXattrs(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xattrs) != 1 || string(xattrs["user.kept"]) != "kept" {
+		t.Errorf("got restored attributes %q, want only user.kept", xattrs)
+	}
+	for _, name := range []string{"trusted.dropped", "security.capability"} {
+		_, err = getXattr(outputPath, name)
+		if !errors.Is(err, syscall.ENODATA) {
+			t.Errorf("%s restored: %v", name, err)
+		}
+	}
+}
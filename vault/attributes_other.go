@@ -0,0 +1,15 @@
+//go:build !linux
+
+package vault
+
+import "io/fs"
+
+// Only the mode and the modification time are recorded on this platform
+func readPlatformAttributes(filePath string, stat fs.FileInfo, skip Attributes, attributes *FileAttributes) error {
+	return nil
+}
+
+// Nothing is recorded besides the mode and the modification time, so the owner is never changed
+func writePlatformAttributes(filePath string, attributes FileAttributes, restore Attributes) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,33 @@
+package vault
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyFileAttributesDropsSetID(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "program")
+	err := os.WriteFile(filePath, []byte("program"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without its recorded owner the file loses the setuid and setgid bits
+	fileMetadata := FileMetadata{Attributes: FileAttributes{
+		Recorded: AttributeMode,
+		Mode:     0755 | fs.ModeSetuid | fs.ModeSetgid,
+	}}
+	err = ApplyFileAttributes(fileMetadata, filePath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode()&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+		t.Errorf("got mode %v, want the set-ID bits dropped", stat.Mode())
+	}
+}
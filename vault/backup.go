@@ -0,0 +1,473 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	A save that rewrites the vault file keeps a copy of the replaced vault file
+	as a generation. A save that appends a segment keeps the generation by
+	reference instead: the vault file before the append is the start of the
+	current vault file, up to its size then. The reference records that size
+	and the trailer found there, so a vault file replaced in the meantime is
+	detected. References are turned into copies before the vault file is
+	rewritten or restored.
+
+	Generations hold the vault file as it was, with the key slots revoked and
+	the passwords changed since: the master key never changes, so these still
+	unlock them. The first save after a key slot is revoked or its credentials
+	change deletes every generation instead of keeping one more.
+*/
+
+const (
+	DefaultBackupCount    = 3                     // Number of generations kept for new vaults
+	backupSuffix          = ".vault.bak"          // Suffix of the backup files
+	backupReferenceSuffix = ".vault.ref"          // Suffix of the generations kept by reference
+	backupTimeFormat      = "20060102-150405.000" // Timestamp in the backup file names
+)
+
+// Magic bytes at the start of every backup reference
+var backupReferenceMagic = [8]byte{'S', 'E', 'C', 'V', 'B', 'R', 'E', 'F'}
+
+// Content of a generation kept by reference
+type backupReference struct {
+	Magic   [8]byte      // Identifies the file as a backup reference
+	Size    int64        // Size of the vault file before the append
+	Trailer vaultTrailer // Trailer at the end of the vault file before the append
+}
+
+// BackupSettings controls the previous generations kept on save
+type BackupSettings struct {
+	Count  int    // Number of previous generations kept, 0 disables backups
+	Folder string // Folder of the backups, empty for the folder of the vault
+}
+
+// Backup is a previous generation of a vault
+type Backup struct {
+	Path      string    // Path of the backup file
+	CreatedAt time.Time // Time the generation was replaced
+	Size      int64     // Size of the backup file in bytes
+	Integrity bool      // Backup passes the integrity check with the vault key
+}
+
+// ListBackups returns the generations of the vault, newest first
+func ListBackups(v *Vault, key []byte) ([]Backup, error) {
+	if v.vaultPath == "" {
+		return nil, ErrNotSaved
+	}
+
+	// Find the backup files of the vault
+	backupPaths, err := findBackups(v)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, backupPath := range backupPaths {
+		// Parse the timestamp in the name
+		createdAt, _ := parseBackupTime(v, backupPath)
+
+		// Verify the backup with the vault key
+		size, integrity, err := checkBackupIntegrity(v, key, backupPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			integrity = false
+		}
+
+		backups = append(backups, Backup{
+			Path:      backupPath,
+			CreatedAt: createdAt,
+			Size:      size,
+			Integrity: integrity,
+		})
+	}
+
+	// Sort the backups from the newest to the oldest
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	return backups, nil
+}
+
+// RestoreBackup replaces the vault file with one of its generations and
+// closes the vault, which has to be loaded again. Unsaved changes are dropped,
+// the replaced vault file is kept as a new generation.
+func RestoreBackup(v *Vault, key []byte, backupPath string) error {
+	if v.vaultFile == nil {
+		return ErrNotSaved
+	}
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// Only restore a backup of this vault that passes the integrity check
+	_, ok := parseBackupTime(v, backupPath)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotBackup, backupPath)
+	}
+	_, integrity, err := checkBackupIntegrity(v, key, backupPath)
+	if err != nil {
+		return err
+	}
+	if !integrity {
+		return fmt.Errorf("%w: backup %s", ErrIntegrityMismatch, filepath.Base(backupPath))
+	}
+
+	// The vault file is replaced, the generations kept by reference need their own copy
+	err = copyBackupReferences(v)
+	if err != nil {
+		return err
+	}
+	backupPath = backupCopyPath(backupPath)
+
+	// Open the backup
+	backupFile, err := os.Open(backupPath)
+	if err != nil {
+		return err
+	}
+	defer backupFile.Close()
+
+	// Keep the current vault file as a generation
+	if v.Backups.Count > 0 {
+		err = writeBackup(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Copy the backup over the vault file
+	err = replaceVaultFile(v, v.vaultPath, func() error {
+		return copyFileAtomic(v.vaultPath, backupFile, tempPattern(v.vaultPath))
+	})
+	if err != nil {
+		return err
+	}
+
+	// The restored generation may be the oldest one over the limit now
+	err = rotateBackups(v)
+	if err != nil {
+		return err
+	}
+
+	return CloseVault(v)
+}
+
+// Keeps the saved vault file as a new generation and removes the oldest ones.
+// Saves that only append to the vault file keep it by reference.
+func backupVault(v *Vault, appending bool) error {
+	if v.Backups.Count <= 0 || v.vaultFile == nil {
+		return nil
+	}
+
+	// A save without changes would only push out an older generation
+	if Pending(v).Empty() {
+		return nil
+	}
+
+	var err error
+	if appending {
+		err = writeBackupReference(v)
+	} else {
+		err = writeBackup(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	return rotateBackups(v)
+}
+
+func writeBackup(v *Vault) error {
+	// Create the backup folder
+	folderPath := backupFolder(v)
+	err := os.MkdirAll(folderPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Copy the vault file as it is on disk
+	stat, err := v.vaultFile.Stat()
+	if err != nil {
+		return err
+	}
+	backupPath := filepath.Join(folderPath, backupPrefix(v)+time.Now().UTC().Format(backupTimeFormat)+backupSuffix)
+	return copyFileAtomic(backupPath, io.NewSectionReader(v.vaultFile, 0, stat.Size()), backupPath+".*"+tempSuffix)
+}
+
+func writeBackupReference(v *Vault) error {
+	// Create the backup folder
+	folderPath := backupFolder(v)
+	err := os.MkdirAll(folderPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Record where the saved vault file ends and its trailer
+	trailer, vaultSize, err := readVaultTrailer(v.vaultFile, int64(len(v.headerBytes)))
+	if err != nil {
+		return err
+	}
+	reference := backupReference{
+		Magic:   backupReferenceMagic,
+		Size:    vaultSize,
+		Trailer: *trailer,
+	}
+	var buf bytes.Buffer
+	err = binary.Write(&buf, binary.LittleEndian, &reference)
+	if err != nil {
+		return err
+	}
+
+	backupPath := filepath.Join(folderPath, backupPrefix(v)+time.Now().UTC().Format(backupTimeFormat)+backupReferenceSuffix)
+	return copyFileAtomic(backupPath, &buf, backupPath+".*"+tempSuffix)
+}
+
+func readBackupReference(backupPath string) (*backupReference, error) {
+	referenceFile, err := os.Open(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	defer referenceFile.Close()
+
+	var reference backupReference
+	err = binary.Read(referenceFile, binary.LittleEndian, &reference)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+	if reference.Magic != backupReferenceMagic {
+		return nil, fmt.Errorf("%w: not a backup reference: %s", ErrCorruptHeader, backupPath)
+	}
+	return &reference, nil
+}
+
+// Turns the generations kept by reference into copies, before the vault file is replaced
+func copyBackupReferences(v *Vault) error {
+	backupPaths, err := findBackups(v)
+	if err != nil {
+		return err
+	}
+
+	for _, backupPath := range backupPaths {
+		if !strings.HasSuffix(backupPath, backupReferenceSuffix) {
+			continue
+		}
+
+		// References that don't match the vault file any more are dropped
+		reference, err := readBackupReference(backupPath)
+		if err == nil && matchesBackupReference(v, reference) {
+			copyPath := backupCopyPath(backupPath)
+			err = copyFileAtomic(copyPath, io.NewSectionReader(v.vaultFile, 0, reference.Size), copyPath+".*"+tempSuffix)
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Remove(backupPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the vault file still starts with the vault the reference points to
+func matchesBackupReference(v *Vault, reference *backupReference) bool {
+	stat, err := v.vaultFile.Stat()
+	if err != nil || reference.Size > stat.Size() {
+		return false
+	}
+	trailer, err := readVaultTrailerAt(v.vaultFile, int64(len(v.headerBytes)), reference.Size)
+	return err == nil && *trailer == reference.Trailer
+}
+
+// Returns the size of the generation and whether it passes the integrity check with the vault key
+func checkBackupIntegrity(v *Vault, key []byte, backupPath string) (int64, bool, error) {
+	if !strings.HasSuffix(backupPath, backupReferenceSuffix) {
+		stat, err := os.Stat(backupPath)
+		if err != nil {
+			return 0, false, err
+		}
+		integrity, err := CheckVaultIntegrity(backupPath, key)
+		return stat.Size(), integrity, err
+	}
+
+	// A generation kept by reference is the start of the vault file
+	reference, err := readBackupReference(backupPath)
+	if err != nil {
+		return 0, false, err
+	}
+	if !matchesBackupReference(v, reference) {
+		return reference.Size, false, nil
+	}
+
+	// Verify the MAC of the vault ending there
+	vaultFile, err := os.Open(v.vaultPath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer vaultFile.Close()
+	_, err = readVaultHeader(vaultFile)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = readVaultMetadata(vaultFile)
+	if err != nil {
+		return 0, false, err
+	}
+	integrity, err := checkVaultTrailerAt(vaultFile, key, reference.Size)
+	return reference.Size, integrity, err
+}
+
+// Path of the copy of a generation kept by reference
+func backupCopyPath(backupPath string) string {
+	if !strings.HasSuffix(backupPath, backupReferenceSuffix) {
+		return backupPath
+	}
+	return strings.TrimSuffix(backupPath, backupReferenceSuffix) + backupSuffix
+}
+
+// PurgeBackups deletes every generation of the vault
+func PurgeBackups(v *Vault) error {
+	if v.vaultPath == "" {
+		return ErrNotSaved
+	}
+
+	backupPaths, err := findBackups(v)
+	if err != nil {
+		return err
+	}
+	for _, backupPath := range backupPaths {
+		err = os.Remove(backupPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateBackups(v *Vault) error {
+	// Find the backup files of the vault
+	generations, err := findBackups(v)
+	if err != nil {
+		return err
+	}
+
+	// Sort the generations from the oldest to the newest
+	sort.Slice(generations, func(i, j int) bool {
+		createdAt1, _ := parseBackupTime(v, generations[i])
+		createdAt2, _ := parseBackupTime(v, generations[j])
+		return createdAt1.Before(createdAt2)
+	})
+
+	// Remove the oldest generations over the limit
+	for len(generations) > v.Backups.Count {
+		err = os.Remove(generations[0])
+		if err != nil {
+			return err
+		}
+		generations = generations[1:]
+	}
+
+	return nil
+}
+
+// Writes everything read from r into a synced temporary file renamed to path
+func copyFileAtomic(path string, r io.Reader, pattern string) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(pattern), filepath.Base(pattern))
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+
+	// Copy and flush to the disk
+	_, err = io.Copy(tempFile, r)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Replace the file with the temporary file
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Make the rename durable
+	return syncDir(filepath.Dir(path))
+}
+
+func backupFolder(v *Vault) string {
+	if v.Backups.Folder != "" {
+		return v.Backups.Folder
+	}
+	return filepath.Dir(v.vaultPath)
+}
+
+// Backup file names start with the vault file name without its extension
+func backupPrefix(v *Vault) string {
+	vaultName := filepath.Base(v.vaultPath)
+	return strings.TrimSuffix(vaultName, filepath.Ext(vaultName)) + "."
+}
+
+// Returns the paths of the generations of the vault, copies and references
+func findBackups(v *Vault) ([]string, error) {
+	var backupPaths []string
+	for _, suffix := range []string{backupSuffix, backupReferenceSuffix} {
+		paths, err := filepath.Glob(filepath.Join(backupFolder(v), backupPrefix(v)+"*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, backupPath := range paths {
+			if _, ok := parseBackupTime(v, backupPath); ok {
+				backupPaths = append(backupPaths, backupPath)
+			}
+		}
+	}
+	return backupPaths, nil
+}
+
+func parseBackupTime(v *Vault, backupPath string) (time.Time, bool) {
+	// Check the backup is in the backup folder of the vault
+	if filepath.Clean(filepath.Dir(backupPath)) != filepath.Clean(backupFolder(v)) {
+		return time.Time{}, false
+	}
+
+	// Parse the timestamp between the prefix and the suffix
+	backupName := filepath.Base(backupPath)
+	suffix := backupSuffix
+	if strings.HasSuffix(backupName, backupReferenceSuffix) {
+		suffix = backupReferenceSuffix
+	}
+	if !strings.HasPrefix(backupName, backupPrefix(v)) || !strings.HasSuffix(backupName, suffix) {
+		return time.Time{}, false
+	}
+	timestamp := strings.TrimSuffix(strings.TrimPrefix(backupName, backupPrefix(v)), suffix)
+	createdAt, err := time.Parse(backupTimeFormat, timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return createdAt, true
+}
@@ -0,0 +1,142 @@
+package vault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBackupsSkipUnchangedSaves(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	addTestFile(t, v, key, "a.txt", []byte("a"))
+	saveTestVault(t, v, key, vaultPath)
+
+	backups, err := ListBackups(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := len(backups)
+
+	// Saving without changes keeps the generations as they are
+	saveTestVault(t, v, key, vaultPath)
+	backups, err = ListBackups(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != count {
+		t.Errorf("got %d generations after a save without changes, want %d", len(backups), count)
+	}
+}
+
+func TestRestoreBackupReference(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	firstID := addTestFile(t, v, key, "first.txt", []byte("first"))
+	saveTestVault(t, v, key, vaultPath)
+	firstSave := readTestVaultFile(t, vaultPath)
+
+	// An appending save keeps the previous generation by reference
+	addTestFile(t, v, key, "second.txt", []byte("second"))
+	saveTestVault(t, v, key, vaultPath)
+	backups, err := ListBackups(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) == 0 || !strings.HasSuffix(backups[0].Path, backupReferenceSuffix) {
+		t.Fatalf("newest generation isn't kept by reference: %+v", backups)
+	}
+	if !backups[0].Integrity || backups[0].Size != int64(len(firstSave)) {
+		t.Fatalf("got generation %+v, want a valid one of %d bytes", backups[0], len(firstSave))
+	}
+
+	// Restoring it brings back the vault file as it was
+	err = RestoreBackup(v, key, backups[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readTestVaultFile(t, vaultPath), firstSave) {
+		t.Error("restored vault file differs from the generation")
+	}
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, map[string][]byte{firstID: []byte("first")})
+}
+
+func TestBackupReferencesSurviveCompaction(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	addTestFile(t, v, key, "first.txt", []byte("first"))
+	saveTestVault(t, v, key, vaultPath)
+	firstSave := readTestVaultFile(t, vaultPath)
+	addTestFile(t, v, key, "second.txt", []byte("second"))
+	saveTestVault(t, v, key, vaultPath)
+
+	// Rewriting the vault file turns the references into copies
+	err := CompactVault(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackups(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, backup := range backups {
+		if strings.HasSuffix(backup.Path, backupReferenceSuffix) {
+			t.Errorf("reference left after compaction: %s", backup.Path)
+		}
+		if !backup.Integrity {
+			t.Errorf("generation fails the integrity check: %s", backup.Path)
+		}
+		if backup.Size == int64(len(firstSave)) && bytes.Equal(readTestVaultFile(t, backup.Path), firstSave) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no copy of the first save among the generations")
+	}
+}
+
+func TestBackupsPurgedWithOldCredentials(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	secondCredentials := Credentials{Password: "second"}
+	err := AddKeySlot(v, key, "second", secondCredentials, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	addTestFile(t, v, key, "a.txt", []byte("a"))
+	saveTestVault(t, v, key, vaultPath)
+
+	// Revoking a key slot deletes the generations it unlocks on the next save
+	checkBackupCount := func(expected int) {
+		t.Helper()
+		backups, err := ListBackups(v, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != expected {
+			t.Fatalf("got %d generations, want %d", len(backups), expected)
+		}
+	}
+	checkBackupCount(2)
+	err = RevokeKeySlot(v, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	checkBackupCount(0)
+
+	// So does changing a password
+	addTestFile(t, v, key, "b.txt", []byte("b"))
+	saveTestVault(t, v, key, vaultPath)
+	checkBackupCount(1)
+	err = ChangePassword(v, testCredentials.Password, "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	checkBackupCount(0)
+
+	// Later saves keep generations again
+	addTestFile(t, v, key, "c.txt", []byte("c"))
+	saveTestVault(t, v, key, vaultPath)
+	checkBackupCount(1)
+}
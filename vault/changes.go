@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// State of the vault when it was loaded or last saved, the changes are found against it
+type savedState struct {
+	files   map[string]string // Paths of the files by ID
+	folders []string          // Paths of the folders
+	backups BackupSettings
+	header  []byte // Encoded header and metadata
+}
+
+// PendingChanges summarizes the changes not saved yet
+type PendingChanges struct {
+	NeverSaved bool     // Vault was created and never saved
+	Added      []string // Paths of the files added
+	Removed    []string // Paths of the removed files when they were saved
+	Moved      []string // Paths of the files moved to another folder
+	Folders    bool     // Folders were created, renamed, moved or deleted
+	Settings   bool     // Key slots, backup settings or the format version changed
+}
+
+// Empty reports whether there is nothing to save
+func (p PendingChanges) Empty() bool {
+	return !p.NeverSaved && len(p.Added) == 0 && len(p.Removed) == 0 && len(p.Moved) == 0 && !p.Folders && !p.Settings
+}
+
+func (p PendingChanges) String() string {
+	if p.NeverSaved {
+		return "vault never saved"
+	}
+
+	var changes []string
+	if len(p.Added) > 0 {
+		changes = append(changes, fmt.Sprintf("%d file(s) added", len(p.Added)))
+	}
+	if len(p.Removed) > 0 {
+		changes = append(changes, fmt.Sprintf("%d file(s) removed", len(p.Removed)))
+	}
+	if len(p.Moved) > 0 {
+		changes = append(changes, fmt.Sprintf("%d file(s) moved", len(p.Moved)))
+	}
+	if p.Folders {
+		changes = append(changes, "folders changed")
+	}
+	if p.Settings {
+		changes = append(changes, "settings changed")
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// IsDirty reports whether the vault differs from the saved vault file
+func IsDirty(v *Vault) bool {
+	return !Pending(v).Empty()
+}
+
+// Pending returns the changes made since the vault was loaded or last saved
+func Pending(v *Vault) PendingChanges {
+	if v.saved == nil {
+		return PendingChanges{NeverSaved: true}
+	}
+
+	changes := PendingChanges{}
+
+	// Compare the files by ID
+	current := map[string]bool{}
+	for _, fileMetadata := range v.FilesMetadata {
+		current[fileMetadata.ID] = true
+		savedPath, ok := v.saved.files[fileMetadata.ID]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, fileMetadata.Path())
+		case savedPath != fileMetadata.Path():
+			changes.Moved = append(changes.Moved, fileMetadata.Path())
+		}
+	}
+	for fileID, savedPath := range v.saved.files {
+		if !current[fileID] {
+			changes.Removed = append(changes.Removed, savedPath)
+		}
+	}
+
+	changes.Folders = strings.Join(v.Folders, "\x00") != strings.Join(v.saved.folders, "\x00")
+
+	// Key slots and the other metadata are compared in their encoded form
+	headerBytes, err := encodeVaultHeader(&v.Header, &v.Metadata)
+	changes.Settings = err != nil || !bytes.Equal(headerBytes, v.saved.header) || v.Backups != v.saved.backups
+
+	return changes
+}
+
+// Records the current state as the saved one
+func markSaved(v *Vault) error {
+	headerBytes, err := encodeVaultHeader(&v.Header, &v.Metadata)
+	if err != nil {
+		return err
+	}
+
+	saved := &savedState{
+		files:   map[string]string{},
+		folders: append([]string{}, v.Folders...),
+		backups: v.Backups,
+		header:  headerBytes,
+	}
+	for _, fileMetadata := range v.FilesMetadata {
+		saved.files[fileMetadata.ID] = fileMetadata.Path()
+	}
+
+	v.saved = saved
+	return nil
+}
@@ -0,0 +1,96 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy decides what happens when an extracted file already exists
+type ConflictPolicy uint8
+
+const (
+	ConflictFail      ConflictPolicy = iota // Extraction fails
+	ConflictSkip                            // File isn't extracted, the existing file is kept
+	ConflictOverwrite                       // Existing file is replaced once the file is extracted
+	ConflictRename                          // File is extracted under a free name with a numbered suffix
+	ConflictAsk                             // Resolve chooses one of the other policies for each conflict
+)
+
+var conflictPolicyNames = []string{"fail", "skip", "overwrite", "rename", "ask"}
+
+func (p ConflictPolicy) String() string {
+	if int(p) < len(conflictPolicyNames) {
+		return conflictPolicyNames[p]
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", uint8(p))
+}
+
+// ParseConflictPolicy returns the policy with the given name
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	for i, policyName := range conflictPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return ConflictPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown conflict policy: %s", ErrInvalidArgument, name)
+}
+
+// ExportOptions decides how the extracted files are written
+type ExportOptions struct {
+	SkipAttributes Attributes     // File attributes not restored
+	RestoreOwner   bool           // Restores the recorded owner, left to the extracting user otherwise
+	Conflict       ConflictPolicy // What happens when the file already exists
+
+	// Called with the path of the existing file when the policy is
+	// ConflictAsk, returns the policy applied to the file
+	Resolve func(filePath string) ConflictPolicy
+}
+
+// Creates the file the content is extracted into, resolving a conflict with
+// an existing file. Returns a nil file if the file is skipped. An overwritten
+// file is extracted into a temporary file, renamed over it once complete.
+func createExportFile(outputFilePath string, options ExportOptions) (*os.File, string, error) {
+	policy := options.Conflict
+	candidatePath := outputFilePath
+	for i := 1; ; i++ {
+		// Never follow or truncate an existing file
+		file, err := os.OpenFile(candidatePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return file, candidatePath, err
+		}
+
+		// Ask once which policy applies to the file
+		if policy == ConflictAsk {
+			if options.Resolve == nil {
+				return nil, "", fmt.Errorf("%w: no conflict resolver for: %s", ErrInvalidArgument, outputFilePath)
+			}
+			policy = options.Resolve(outputFilePath)
+		}
+
+		switch policy {
+		case ConflictSkip:
+			return nil, "", nil
+		case ConflictOverwrite:
+			file, err = os.CreateTemp(filepath.Dir(outputFilePath), "."+filepath.Base(outputFilePath)+".*"+tempSuffix)
+			return file, outputFilePath, err
+		case ConflictRename:
+			candidatePath = numberedPath(outputFilePath, i)
+		default:
+			return nil, "", err
+		}
+	}
+}
+
+// Returns the path with a numbered suffix before the extension, like "name (1).txt"
+func numberedPath(filePath string, number int) string {
+	dir, name := filepath.Split(filePath)
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), number, ext))
+}
@@ -0,0 +1,162 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates a vault holding a.txt and a folder on the disk with an a.txt of its own
+func createConflictTest(t *testing.T) (*Vault, []byte, string, string) {
+	t.Helper()
+
+	v, key, _ := createTestVault(t)
+	fileID := addTestFile(t, v, key, "a.txt", []byte("from the vault"))
+	exportFolderPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(exportFolderPath, "a.txt"), []byte("existing"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, key, fileID, exportFolderPath
+}
+
+func checkTestFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte(content)) {
+		t.Errorf("%s holds %q, want %q", filepath.Base(filePath), data, content)
+	}
+}
+
+func TestExportConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy   ConflictPolicy
+		resolve  func(filePath string) ConflictPolicy
+		target   string // Path of the exported file relative to the folder, empty if skipped
+		existing string // Content of the existing file afterwards
+	}{
+		{policy: ConflictSkip, existing: "existing"},
+		{policy: ConflictOverwrite, target: "a.txt", existing: "from the vault"},
+		{policy: ConflictRename, target: "a (1).txt", existing: "existing"},
+		{
+			policy:   ConflictAsk,
+			resolve:  func(string) ConflictPolicy { return ConflictRename },
+			target:   "a (1).txt",
+			existing: "existing",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			v, key, fileID, exportFolderPath := createConflictTest(t)
+
+			outputFilePath, err := ExportFile(v, key, fileID, exportFolderPath, ExportOptions{Conflict: test.policy, Resolve: test.resolve})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.target == "" {
+				if outputFilePath != "" {
+					t.Errorf("skipped file exported to %s", outputFilePath)
+				}
+			} else {
+				if outputFilePath != filepath.Join(exportFolderPath, test.target) {
+					t.Errorf("got %s, want %s", outputFilePath, test.target)
+				}
+				checkTestFile(t, outputFilePath, "from the vault")
+			}
+			checkTestFile(t, filepath.Join(exportFolderPath, "a.txt"), test.existing)
+		})
+	}
+}
+
+func TestExportConflictFails(t *testing.T) {
+	v, key, fileID, exportFolderPath := createConflictTest(t)
+
+	// The default policy keeps the existing file
+	_, err := ExportFile(v, key, fileID, exportFolderPath, ExportOptions{})
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("got %v, want %v", err, fs.ErrExist)
+	}
+	checkTestFile(t, filepath.Join(exportFolderPath, "a.txt"), "existing")
+
+	// Asking needs someone to answer
+	_, err = ExportFile(v, key, fileID, exportFolderPath, ExportOptions{Conflict: ConflictAsk})
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("got %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestExportOverwriteReplacesSymlink(t *testing.T) {
+	v, key, fileID, _ := createConflictTest(t)
+
+	// An existing link is replaced, the file it points to is left alone
+	targetPath := filepath.Join(t.TempDir(), "target.txt")
+	err := os.WriteFile(targetPath, []byte("target"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportFolderPath := t.TempDir()
+	err = os.Symlink(targetPath, filepath.Join(exportFolderPath, "a.txt"))
+	if err != nil {
+		t.Skipf("can't create symbolic links: %v", err)
+	}
+
+	_, err = ExportFile(v, key, fileID, exportFolderPath, ExportOptions{Conflict: ConflictOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, targetPath, "target")
+	stat, err := os.Lstat(filepath.Join(exportFolderPath, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.Mode().IsRegular() {
+		t.Errorf("got mode %v, want a regular file", stat.Mode())
+	}
+	checkTestFile(t, filepath.Join(exportFolderPath, "a.txt"), "from the vault")
+}
+
+func TestExportRejectsCraftedNames(t *testing.T) {
+	v, key, _ := createTestVault(t)
+	fileID := addTestFile(t, v, key, "a.txt", []byte("a"))
+	exportFolderPath := filepath.Join(t.TempDir(), "export")
+	err := os.Mkdir(exportFolderPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Names read from a crafted vault can't point outside the export folder
+	for _, name := range []string{"../escaped.txt", "..", "sub/a.txt", `sub\a.txt`} {
+		v.FilesMetadata[0].Name = name
+		_, err = ExportFile(v, key, fileID, exportFolderPath, ExportOptions{})
+		if !errors.Is(err, ErrInvalidName) {
+			t.Errorf("export of %q: got %v, want %v", name, err, ErrInvalidName)
+		}
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(exportFolderPath), "escaped.txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file written outside the export folder: %v", err)
+	}
+
+	// So can't folders
+	v.FilesMetadata[0].Name = "a.txt"
+	v.FilesMetadata[0].Folder = "../escaped"
+	v.Folders = append(v.Folders, "../escaped")
+	summary, err := ExportFolder(v, key, "", exportFolderPath, TransferOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Transferred != 0 || summary.Failed == 0 {
+		t.Errorf("got %d transferred and %d failed, want only failures", summary.Transferred, summary.Failed)
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(exportFolderPath), "escaped"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("folder created outside the export folder: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors of the vault package, the returned errors wrap them with details
+// and are matched with errors.Is
+var (
+	ErrNotVault           = errors.New("not a secure vault file")
+	ErrVerificationFailed = errors.New("vault verification failed after save")
+	ErrReadOnly           = errors.New("vault is opened read-only")
+	ErrWrongPassword      = errors.New("wrong password or keyfile")
+	ErrCorruptHeader      = errors.New("vault header is corrupt")
+	ErrIntegrityMismatch  = errors.New("integrity check failed")
+	ErrFileNotFound       = errors.New("file not found")
+	ErrUnsupportedVersion = errors.New("unsupported vault format version")
+	ErrTruncated          = errors.New("vault file is truncated")
+	ErrCorruptJournal     = errors.New("vault journal is corrupt")
+	ErrUnfinishedSave     = errors.New("vault is being saved or its last save was interrupted")
+	ErrNotSaved           = errors.New("vault is not saved yet")
+	ErrVaultClosed        = errors.New("vault file is closed")
+	ErrNotBackup          = errors.New("not a backup of the vault")
+	ErrFolderNotFound     = errors.New("folder not found")
+	ErrFolderNotEmpty     = errors.New("folder is not empty")
+	ErrAlreadyExists      = errors.New("already exists in vault")
+	ErrInvalidName        = errors.New("invalid name")
+	ErrKeySlotNotFound    = errors.New("key slot not found")
+	ErrKeySlotExists      = errors.New("key slot already exists")
+	ErrSymlinkLoop        = errors.New("symbolic link loop")
+	ErrInvalidArgument    = errors.New("invalid argument")
+)
+
+// UnsupportedVersionError is returned when a vault was written in a format version this build cannot read
+type UnsupportedVersionError struct {
+	Version uint16 // Format version found in the vault header
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrUnsupportedVersion, e.Version)
+}
+
+func (e *UnsupportedVersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
+// Reports a read that ended before the end of what the vault file describes as truncated
+func truncatedError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrTruncated, err)
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"secure_vault/vault/utils"
+	"strings"
+	"testing"
+)
+
+func checkTestError(t *testing.T, operation string, err error, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Errorf("%s: got %v, want %v", operation, err, want)
+	}
+}
+
+func TestOperationErrors(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	addTestFile(t, v, key, "a.txt", []byte("a"))
+	err := CreateFolder(v, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddStreamToVault(v, key, "docs", "b.txt", strings.NewReader("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddKeySlot(v, key, "spare", Credentials{Password: "spare"}, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+
+	// Files and folders
+	_, err = FileByPath(v, "missing.txt")
+	checkTestError(t, "missing file", err, ErrFileNotFound)
+	err = AddStreamToVault(v, key, "missing", "c.txt", strings.NewReader("c"))
+	checkTestError(t, "add to a missing folder", err, ErrFolderNotFound)
+	_, _, err = ListFolder(v, "missing")
+	checkTestError(t, "list a missing folder", err, ErrFolderNotFound)
+	err = DeleteFolder(v, "docs", false)
+	checkTestError(t, "delete a folder with files", err, ErrFolderNotEmpty)
+	err = CreateFolder(v, "a.txt")
+	checkTestError(t, "create a folder over a file", err, ErrAlreadyExists)
+	err = RenameFolder(v, "docs", "a/b")
+	checkTestError(t, "rename a folder with a separator", err, ErrInvalidName)
+	err = DeleteFolder(v, "", true)
+	checkTestError(t, "delete the root folder", err, ErrInvalidArgument)
+
+	// Key slots
+	err = AddKeySlot(v, key, "spare", Credentials{Password: "other"}, testKDFParams)
+	checkTestError(t, "add a duplicate key slot", err, ErrKeySlotExists)
+	err = RevokeKeySlot(v, "missing")
+	checkTestError(t, "revoke a missing key slot", err, ErrKeySlotNotFound)
+	err = RevokeKeySlot(v, "spare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RevokeKeySlot(v, DefaultKeySlotLabel)
+	checkTestError(t, "revoke the last key slot", err, ErrInvalidArgument)
+
+	// Backups and options
+	err = RestoreBackup(v, key, filepath.Join(filepath.Dir(vaultPath), "other.vault"))
+	checkTestError(t, "restore a file that is not a backup", err, ErrNotBackup)
+	unsaved, unsavedKey, err := CreateVault(testCredentials, utils.DefaultCipherSuite, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ListBackups(unsaved, unsavedKey)
+	checkTestError(t, "list the backups of an unsaved vault", err, ErrNotSaved)
+	_, err = ParseConflictPolicy("sometimes")
+	checkTestError(t, "parse an unknown conflict policy", err, ErrInvalidArgument)
+}
+
+func TestLoadErrors(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	addTestFile(t, v, key, "a.txt", []byte("a"))
+	saveTestVault(t, v, key, vaultPath)
+	err := CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := readTestVaultFile(t, vaultPath)
+
+	// Writes a modified copy of the vault file
+	writeVault := func(name string, content []byte) string {
+		path := filepath.Join(filepath.Dir(vaultPath), name)
+		err := os.WriteFile(path, content, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	loadOptions := LoadOptions{ReadOnly: true}
+
+	_, _, err = LoadVault(Credentials{Password: "wrong"}, vaultPath, loadOptions)
+	checkTestError(t, "wrong password", err, ErrWrongPassword)
+
+	textPath := writeVault("notes.txt", []byte(strings.Repeat("not a vault\n", 20)))
+	_, _, err = LoadVault(testCredentials, textPath, loadOptions)
+	checkTestError(t, "text file", err, ErrNotVault)
+
+	future := append([]byte(nil), saved...)
+	binary.LittleEndian.PutUint16(future[8:], 0xffff)
+	_, _, err = LoadVault(testCredentials, writeVault("future.vault", future), loadOptions)
+	var versionErr *UnsupportedVersionError
+	if !errors.As(err, &versionErr) || versionErr.Version != 0xffff {
+		t.Errorf("future version: got %v, want an UnsupportedVersionError", err)
+	}
+	checkTestError(t, "future version", err, ErrUnsupportedVersion)
+
+	// Half of the trailer is missing
+	truncated := saved[:int64(len(saved))-trailerSize/2]
+	_, _, err = LoadVault(testCredentials, writeVault("truncated.vault", truncated), loadOptions)
+	checkTestError(t, "truncated trailer", err, ErrTruncated)
+
+	// Only the start of the header is left
+	_, _, err = LoadVault(testCredentials, writeVault("header.vault", saved[:32]), loadOptions)
+	checkTestError(t, "partial header", err, ErrTruncated)
+
+	// The journal records a size past the end of the vault file
+	err = writeJournal(vaultPath, int64(len(saved))+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RecoverVault(vaultPath)
+	checkTestError(t, "journal past the end", err, ErrCorruptJournal)
+}
+
+func TestReadVaultTrailerBounds(t *testing.T) {
+	const headerSize = 64
+	tests := []struct {
+		name    string
+		trailer vaultTrailer
+	}{
+		{"offset in the header", vaultTrailer{FilesMetadataOffset: headerSize - 1, FilesMetadataSize: 1}},
+		{"negative offset", vaultTrailer{FilesMetadataOffset: -1, FilesMetadataSize: 1}},
+		{"negative size", vaultTrailer{FilesMetadataOffset: headerSize, FilesMetadataSize: -1}},
+		{"past the end", vaultTrailer{FilesMetadataOffset: headerSize, FilesMetadataSize: 2}},
+	}
+	for _, test := range tests {
+		// A header, one byte of files metadata and the trailer
+		vaultPath := filepath.Join(t.TempDir(), "test.vault")
+		vaultFile, err := os.Create(vaultPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer vaultFile.Close()
+		_, err = vaultFile.Write(make([]byte, headerSize+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = binary.Write(vaultFile, binary.LittleEndian, &test.trailer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = readVaultTrailerAt(vaultFile, headerSize, headerSize+1+trailerSize)
+		checkTestError(t, test.name, err, ErrTruncated)
+	}
+}
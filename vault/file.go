@@ -1,16 +1,25 @@
 package vault
 
 import (
-	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"secure_vault/vault/utils"
 	"time"
 )
 
-func AddFileToVault(v *Vault, key []byte, filePath string, deleteFile bool) error {
+// Size of the random file IDs in bytes
+const fileIDSize = 16
+
+// AddFileToVault adds the file with its attributes, except the skipped ones
+func AddFileToVault(v *Vault, key []byte, filePath string, folderPath string, deleteFile bool, skipAttributes Attributes) error {
 	// Open the file to be added
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -20,37 +29,26 @@ func AddFileToVault(v *Vault, key []byte, filePath string, deleteFile bool) erro
 	// Get file info
 	stat, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	// Read all the file's content into a []byte
-	data, err := io.ReadAll(file)
+	// Record the attributes before reading changes the access time
+	attributes, err := readFileAttributes(filePath, stat, skipAttributes)
 	if err != nil {
+		file.Close()
 		return err
 	}
 
+	// Stream the file's content into the vault
+	err = addStream(v, key, folderPath, stat.Name(), file, attributes)
+
 	// Close the file
 	file.Close()
-
-	// Encrypt the file content
-	encryptedData, err := utils.Encrypt(data, key)
 	if err != nil {
 		return err
 	}
 
-	// Create file metadata
-	fileMetadata := FileMetadata{
-		Name:          stat.Name(),
-		Index:         int64(len(v.FilesMetadata)),
-		Offset:        int64(len(v.Files)),
-		IntegrityHash: utils.GenerateDataHash(encryptedData),
-		AddedAt:       time.Now().Truncate(0),
-	}
-
-	// Update the vault structure
-	v.Files = append(v.Files, encryptedData...)
-	v.FilesMetadata = append(v.FilesMetadata, fileMetadata)
-
 	// Delete the original file if requested
 	if deleteFile {
 		err = os.Remove(filePath)
@@ -62,114 +60,369 @@ func AddFileToVault(v *Vault, key []byte, filePath string, deleteFile bool) erro
 	return nil
 }
 
-func RemoveFileFromVault(v *Vault, fileIndex int64) error {
-	// If the file doesn't exist, return an error
-	if fileIndex < 0 || fileIndex >= int64(len(v.FilesMetadata)) {
-		return fmt.Errorf("file index not found: %d", fileIndex)
+// AddStreamToVault adds the content of the reader, without file attributes
+func AddStreamToVault(v *Vault, key []byte, folderPath string, name string, r io.Reader) error {
+	return addStream(v, key, folderPath, name, r, FileAttributes{})
+}
+
+func addStream(v *Vault, key []byte, folderPath string, name string, r io.Reader, attributes FileAttributes) error {
+	if v.readOnly {
+		return ErrReadOnly
 	}
 
-	// Get file offsets
-	fileStartOffset, fileEndOffset, _ := getFileOffsets(v, fileIndex)
-	removedFileSize := fileEndOffset - fileStartOffset
+	// Validation
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+	err = validateName(name)
+	if err != nil {
+		return err
+	}
+	if !FolderExists(v, folderPath) {
+		return fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+	err = checkPathFree(v, folderPath, name)
+	if err != nil {
+		return err
+	}
 
-	// Remove the file content from the Files list
-	v.Files = append(v.Files[:fileStartOffset], v.Files[fileEndOffset:]...)
+	// Create the staging file on the first addition
+	if v.stagingFile == nil {
+		stagingFile, err := os.CreateTemp("", "secure_vault-*.staging")
+		if err != nil {
+			return err
+		}
+		v.stagingFile = stagingFile
+	}
 
-	// Remove the file metadata from the FilesMetadata list
-	v.FilesMetadata = append(v.FilesMetadata[:fileIndex], v.FilesMetadata[fileIndex+1:]...)
+	// Append to the end of the staging file
+	offset, err := v.stagingFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
 
-	// Recalculate the indices for the remaining files
-	for i := fileIndex; i < int64(len(v.FilesMetadata)); i++ {
-		v.FilesMetadata[i].Index = int64(i)
+	// Every file gets an identifier that doesn't change while it is in the vault
+	fileID, err := newFileID()
+	if err != nil {
+		return err
 	}
 
-	// Recalculate the offsets for the remaining files
-	for i := fileIndex; i < int64(len(v.FilesMetadata)); i++ {
-		v.FilesMetadata[i].Offset -= removedFileSize
+	// Encrypt the content in chunks into the staging file, computing the integrity hash on the way
+	counter := &countingWriter{}
+	mac, err := newFileMAC(key, true)
+	if err != nil {
+		return err
 	}
+	size, err := utils.EncryptStream(io.MultiWriter(v.stagingFile, mac, counter), r, key, v.Header.Cipher, utils.DefaultChunkSize)
+	if err != nil {
+		return err
+	}
+
+	// Create file metadata
+	fileMetadata := FileMetadata{
+		ID:            fileID,
+		Name:          name,
+		Folder:        folderPath,
+		Offset:        offset,
+		EncryptedSize: counter.n,
+		Size:          size,
+		ChunkSize:     utils.DefaultChunkSize,
+		IntegrityHash: mac.Sum(nil),
+		FileMACKey:    true,
+		AddedAt:       time.Now().Truncate(0),
+		Attributes:    attributes,
+		staged:        true,
+	}
+
+	// Update the vault structure
+	v.FilesMetadata = append(v.FilesMetadata, fileMetadata)
 
 	return nil
 }
 
-func ExtractFileFromVault(v *Vault, key []byte, fileIndex int64, extractFolderPath string) error {
+func RemoveFileFromVault(v *Vault, fileID string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
 	// If the file doesn't exist, return an error
-	if fileIndex < 0 || fileIndex >= int64(len(v.FilesMetadata)) {
-		return fmt.Errorf("file index not found: %d", fileIndex)
+	fileIndex, err := findFileIndex(v, fileID)
+	if err != nil {
+		return err
+	}
+
+	// The saved content stays in the vault file until it is compacted
+	fileMetadata := v.FilesMetadata[fileIndex]
+	if !fileMetadata.staged {
+		v.Tombstones = append(v.Tombstones, Tombstone{
+			Offset: fileMetadata.Offset,
+			Size:   fileMetadata.EncryptedSize,
+		})
 	}
 
-	// Get the file content from the vault
-	fileData, _ := getFile(v, fileIndex)
+	// Remove the file metadata from the FilesMetadata list
+	v.FilesMetadata = append(v.FilesMetadata[:fileIndex], v.FilesMetadata[fileIndex+1:]...)
+
+	return nil
+}
 
-	// Decrypt the file content
-	file, err := utils.Decrypt(fileData, key)
+// FileByID returns the metadata of the file with the given ID
+func FileByID(v *Vault, fileID string) (FileMetadata, error) {
+	fileIndex, err := findFileIndex(v, fileID)
 	if err != nil {
-		return err
+		return FileMetadata{}, err
 	}
+	return v.FilesMetadata[fileIndex], nil
+}
 
-	// Combine the extractPath with the file name to get the full path
-	fileName := v.FilesMetadata[fileIndex].Name
-	outputFilePath := filepath.Join(extractFolderPath, fileName)
+// FileByPath returns the metadata of the file with the given path inside the vault
+func FileByPath(v *Vault, vaultPath string) (FileMetadata, error) {
+	fileIndex := findFile(v, vaultPath)
+	if fileIndex == -1 {
+		return FileMetadata{}, fmt.Errorf("%w: %s", ErrFileNotFound, vaultPath)
+	}
+	return v.FilesMetadata[fileIndex], nil
+}
 
-	// Open the file at the specified extraction path
-	outputFile, err := os.Create(outputFilePath)
+// ExportFile writes a decrypted copy of the file into the folder and restores
+// its attributes, except the skipped ones. The file stays in the vault.
+// Returns the path of the written file, empty if the file was skipped.
+func ExportFile(v *Vault, key []byte, fileID string, exportFolderPath string, options ExportOptions) (string, error) {
+	// If the file doesn't exist, return an error
+	fileMetadata, err := FileByID(v, fileID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer outputFile.Close()
 
-	// Write the decrypted file data to the extraction path
-	_, err = outputFile.Write(file)
+	// Names of crafted vaults could point outside the folder
+	err = validateName(fileMetadata.Name)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	// Combine the exportPath with the file name to get the full path
+	outputFilePath := filepath.Join(exportFolderPath, fileMetadata.Name)
+
+	// Open the file at the specified export path, unless it is skipped
+	outputFile, outputFilePath, err := createExportFile(outputFilePath, options)
+	if err != nil || outputFile == nil {
+		return "", err
+	}
+
+	// Stream the decrypted file data to the export path
+	err = ExtractFileToWriter(v, key, fileID, outputFile)
+	closeErr := outputFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		skip := options.SkipAttributes
+		if !options.RestoreOwner {
+			skip |= AttributeOwner
+		}
+		err = ApplyFileAttributes(fileMetadata, outputFile.Name(), skip)
+	}
+
+	// An overwritten file is only replaced by a complete copy
+	if err == nil && outputFile.Name() != outputFilePath {
+		err = os.Rename(outputFile.Name(), outputFilePath)
+	}
+
+	// Don't leave a partially exported file behind
+	if err != nil {
+		os.Remove(outputFile.Name())
+		return "", err
+	}
+
+	return outputFilePath, nil
+}
+
+// TakeFile writes the decrypted file into the folder and removes it from the vault.
+// A skipped file stays in the vault and the returned path is empty.
+func TakeFile(v *Vault, key []byte, fileID string, takeFolderPath string, options ExportOptions) (string, error) {
+	if v.readOnly {
+		return "", ErrReadOnly
+	}
+
+	// Write the file out first, so it is never lost
+	outputFilePath, err := ExportFile(v, key, fileID, takeFolderPath, options)
+	if err != nil || outputFilePath == "" {
+		return "", err
 	}
 
 	// Remove the file from the vault
-	err = RemoveFileFromVault(v, fileIndex)
+	return outputFilePath, RemoveFileFromVault(v, fileID)
+}
+
+func ExtractFileToWriter(v *Vault, key []byte, fileID string, w io.Writer) error {
+	// Get the encrypted file content from the vault
+	fileMetadata, err := FileByID(v, fileID)
+	if err != nil {
+		return err
+	}
+	fileReader, err := getFileReader(v, fileMetadata)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	// Files added before chunking are encrypted as a single blob
+	if fileMetadata.ChunkSize == 0 {
+		err = extractLegacyFile(v, key, fileReader, w)
+	} else {
+		// Decrypt the file content chunk by chunk
+		_, err = utils.DecryptStream(w, fileReader, key, v.Header.Cipher, int(fileMetadata.ChunkSize))
+	}
 
-func CheckFileIntegrity(v *Vault, fileIndex int64) (bool, error) {
-	// If the file doesn't exist, return an error
-	if fileIndex < 0 || fileIndex >= int64(len(v.FilesMetadata)) {
-		return false, fmt.Errorf("file index not found: %d", fileIndex)
+	// The key is right, so content that doesn't authenticate was modified
+	if errors.Is(err, utils.ErrDecryptionFailed) {
+		return fmt.Errorf("%w: %s", ErrIntegrityMismatch, fileMetadata.Name)
 	}
+	return err
+}
 
-	// Get the expected hash
-	fileMetadata := v.FilesMetadata[fileIndex]
-	expectedHash := fileMetadata.IntegrityHash
+func CheckFileIntegrity(v *Vault, key []byte, fileID string) (bool, error) {
+	check := &FileCheck{FileID: fileID}
+	_, integrity, err := ContinueFileCheck(v, key, check, math.MaxInt64)
+	return integrity, err
+}
+
+// FileCheck is an integrity check of a file done a piece at a time, the vault
+// can be used and changed between the pieces
+type FileCheck struct {
+	FileID  string
+	mac     hash.Hash
+	staged  bool  // Where the content was when the check started
+	offset  int64 // Where the content was when the check started
+	checked int64 // Bytes of the content hashed so far
+}
 
+// ContinueFileCheck hashes up to size more bytes of the file, and reports
+// whether the check is complete and whether the file passed it. The check
+// starts over if the content moved since the last piece.
+func ContinueFileCheck(v *Vault, key []byte, check *FileCheck, size int64) (bool, bool, error) {
 	// Get the file
-	fileData, _ := getFile(v, fileIndex)
+	fileMetadata, err := FileByID(v, check.FileID)
+	if err != nil {
+		return false, false, err
+	}
+	fileReader, err := getFileReader(v, fileMetadata)
+	if err != nil {
+		return false, false, err
+	}
+
+	// Start over if the content moved, a save may have written it elsewhere
+	if check.mac == nil || check.staged != fileMetadata.staged || check.offset != fileMetadata.Offset {
+		check.mac, err = newFileMAC(key, fileMetadata.FileMACKey)
+		if err != nil {
+			return false, false, err
+		}
+		check.staged = fileMetadata.staged
+		check.offset = fileMetadata.Offset
+		check.checked = 0
+	}
+
+	// Compute the real hash of the next piece
+	size = min(size, fileMetadata.EncryptedSize-check.checked)
+	_, err = io.Copy(check.mac, io.NewSectionReader(fileReader, check.checked, size))
+	if err != nil {
+		return false, false, err
+	}
+	check.checked += size
+	if check.checked < fileMetadata.EncryptedSize {
+		return false, false, nil
+	}
 
-	// Compute the real hash
-	hash := utils.GenerateDataHash(fileData)
+	// Compare with the expected hash
+	return true, hmac.Equal(check.mac.Sum(nil), fileMetadata.IntegrityHash), nil
+}
 
-	return bytes.Equal(hash, expectedHash), nil
+func VerifyFile(v *Vault, key []byte, fileID string) error {
+	// Authenticate every chunk without keeping the plaintext
+	return ExtractFileToWriter(v, key, fileID, io.Discard)
 }
 
-func getFileOffsets(v *Vault, fileIndex int64) (int64, int64, error) {
-	// If the file doesn't exist, return an error
-	if fileIndex < 0 || fileIndex >= int64(len(v.FilesMetadata)) {
-		return 0, 0, fmt.Errorf("file index not found: %d", fileIndex)
+func getFileReader(v *Vault, fileMetadata FileMetadata) (*io.SectionReader, error) {
+	// Read the content from the staging file if it isn't saved yet
+	if fileMetadata.staged {
+		return io.NewSectionReader(v.stagingFile, fileMetadata.Offset, fileMetadata.EncryptedSize), nil
 	}
 
-	if fileIndex == int64(len(v.FilesMetadata)-1) {
-		return int64(v.FilesMetadata[fileIndex].Offset), int64(len(v.Files)), nil
-	} else {
-		return int64(v.FilesMetadata[fileIndex].Offset), int64(v.FilesMetadata[fileIndex+1].Offset), nil
+	// Otherwise read it from the vault file
+	if v.vaultFile == nil {
+		return nil, ErrVaultClosed
+	}
+
+	// Check the content lies in the vault file
+	stat, err := v.vaultFile.Stat()
+	if err != nil {
+		return nil, err
 	}
+	if fileMetadata.Offset+fileMetadata.EncryptedSize > stat.Size() {
+		return nil, fmt.Errorf("%w: content of %s ends past the end of the vault file", ErrTruncated, fileMetadata.Name)
+	}
+
+	return io.NewSectionReader(v.vaultFile, fileMetadata.Offset, fileMetadata.EncryptedSize), nil
 }
 
-func getFile(v *Vault, fileIndex int64) ([]byte, error) {
-	// If the file doesn't exist, return an error
-	if fileIndex < 0 || fileIndex >= int64(len(v.FilesMetadata)) {
-		return nil, fmt.Errorf("file index not found: %d", fileIndex)
+// Returns the MAC computing the integrity hash of a file
+func newFileMAC(key []byte, fileMACKey bool) (hash.Hash, error) {
+	// Hashes written before the file MAC subkey are keyed with the master key
+	if !fileMACKey {
+		return utils.NewDataMAC(key), nil
+	}
+
+	macKey, err := utils.DeriveSubkey(key, utils.FileMACContext)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.WipeKey(macKey)
+
+	return utils.NewDataMAC(macKey), nil
+}
+
+// Returns a new random file ID
+func newFileID() (string, error) {
+	id := make([]byte, fileIDSize)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Gives the files loaded without an ID, from vaults written before IDs, a new one
+func assignFileIDs(v *Vault) error {
+	for i := range v.FilesMetadata {
+		if v.FilesMetadata[i].ID != "" {
+			continue
+		}
+
+		fileID, err := newFileID()
+		if err != nil {
+			return err
+		}
+		v.FilesMetadata[i].ID = fileID
 	}
+	return nil
+}
+
+// Returns the position of the file with the given ID in the files metadata
+func findFileIndex(v *Vault, fileID string) (int, error) {
+	for i, fileMetadata := range v.FilesMetadata {
+		if fileMetadata.ID == fileID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+}
+
+// Counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
 
-	fileStartOffset, fileEndOffset, _ := getFileOffsets(v, fileIndex)
-	return v.Files[fileStartOffset:fileEndOffset], nil
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
 }
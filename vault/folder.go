@@ -0,0 +1,313 @@
+package vault
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+	Folders are identified by their slash separated path inside the vault,
+	the root folder is the empty path. Every file records the path of the
+	folder containing it, and the vault keeps the paths of all folders so
+	empty folders survive a save.
+*/
+
+const folderSeparator = "/"
+
+// Path returns the path of the file inside the vault
+func (f FileMetadata) Path() string {
+	return joinVaultPath(f.Folder, f.Name)
+}
+
+// CreateFolder creates the folder and its missing parents
+func CreateFolder(v *Vault, folderPath string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Create every missing folder from the root down
+	elements := splitFolderPath(folderPath)
+	for i := range elements {
+		parentPath := strings.Join(elements[:i+1], folderSeparator)
+		if findFile(v, parentPath) != -1 {
+			return fmt.Errorf("%w: a file exists with the folder name: %s", ErrAlreadyExists, parentPath)
+		}
+		if !FolderExists(v, parentPath) {
+			v.Folders = append(v.Folders, parentPath)
+		}
+	}
+
+	sort.Strings(v.Folders)
+	return nil
+}
+
+// FolderExists reports whether the folder exists, the root folder always does
+func FolderExists(v *Vault, folderPath string) bool {
+	if folderPath == "" {
+		return true
+	}
+	for _, folder := range v.Folders {
+		if folder == folderPath {
+			return true
+		}
+	}
+	return false
+}
+
+// ListFolder returns the paths of the direct subfolders and the IDs of the files in the folder
+func ListFolder(v *Vault, folderPath string) ([]string, []string, error) {
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !FolderExists(v, folderPath) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+
+	// Find the direct subfolders
+	subfolders := []string{}
+	for _, folder := range v.Folders {
+		if parentFolder(folder) == folderPath {
+			subfolders = append(subfolders, folder)
+		}
+	}
+
+	// Find the files in the folder
+	fileIDs := []string{}
+	for _, fileMetadata := range v.FilesMetadata {
+		if fileMetadata.Folder == folderPath {
+			fileIDs = append(fileIDs, fileMetadata.ID)
+		}
+	}
+
+	return subfolders, fileIDs, nil
+}
+
+// RenameFolder gives the folder a new name in the same parent folder
+func RenameFolder(v *Vault, folderPath string, newName string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Validation
+	err = validateName(newName)
+	if err != nil {
+		return err
+	}
+
+	return relocateFolder(v, folderPath, joinVaultPath(parentFolder(folderPath), newName))
+}
+
+// MoveFolder moves the folder with its content into another folder
+func MoveFolder(v *Vault, folderPath string, newParentPath string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+	newParentPath, err = cleanFolderPath(newParentPath)
+	if err != nil {
+		return err
+	}
+
+	// Validation
+	if !FolderExists(v, newParentPath) {
+		return fmt.Errorf("%w: %s", ErrFolderNotFound, newParentPath)
+	}
+	if isInFolder(newParentPath, folderPath) {
+		return fmt.Errorf("%w: cannot move a folder into itself: %s", ErrInvalidArgument, folderPath)
+	}
+
+	return relocateFolder(v, folderPath, joinVaultPath(newParentPath, path.Base(folderPath)))
+}
+
+// DeleteFolder deletes the folder, its content is deleted too if recursive is set
+func DeleteFolder(v *Vault, folderPath string, recursive bool) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	folderPath, err := cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Validation
+	if folderPath == "" {
+		return fmt.Errorf("%w: cannot delete the root folder", ErrInvalidArgument)
+	}
+	if !FolderExists(v, folderPath) {
+		return fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+
+	// Find the content of the folder
+	var fileIDs []string
+	for _, fileMetadata := range v.FilesMetadata {
+		if isInFolder(fileMetadata.Folder, folderPath) {
+			fileIDs = append(fileIDs, fileMetadata.ID)
+		}
+	}
+	var folders []string
+	for _, folder := range v.Folders {
+		if !isInFolder(folder, folderPath) {
+			folders = append(folders, folder)
+		}
+	}
+	if !recursive && (len(fileIDs) > 0 || len(folders) < len(v.Folders)-1) {
+		return fmt.Errorf("%w: %s", ErrFolderNotEmpty, folderPath)
+	}
+
+	// Remove the files
+	for _, fileID := range fileIDs {
+		err = RemoveFileFromVault(v, fileID)
+		if err != nil {
+			return err
+		}
+	}
+
+	v.Folders = folders
+	return nil
+}
+
+// MoveFile moves the file into another folder
+func MoveFile(v *Vault, fileID string, folderPath string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// If the file doesn't exist, return an error
+	fileIndex, err := findFileIndex(v, fileID)
+	if err != nil {
+		return err
+	}
+
+	folderPath, err = cleanFolderPath(folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Validation
+	if !FolderExists(v, folderPath) {
+		return fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+	err = checkPathFree(v, folderPath, v.FilesMetadata[fileIndex].Name)
+	if err != nil {
+		return err
+	}
+
+	v.FilesMetadata[fileIndex].Folder = folderPath
+	return nil
+}
+
+// Moves the folder and its content to the new path
+func relocateFolder(v *Vault, folderPath string, newPath string) error {
+	// Validation
+	if folderPath == "" {
+		return fmt.Errorf("%w: cannot move the root folder", ErrInvalidArgument)
+	}
+	if !FolderExists(v, folderPath) {
+		return fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+	if newPath == folderPath {
+		return nil
+	}
+	if FolderExists(v, newPath) || findFile(v, newPath) != -1 {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, newPath)
+	}
+
+	// Replace the folder path prefix of the subfolders and the files
+	for i, folder := range v.Folders {
+		if isInFolder(folder, folderPath) {
+			v.Folders[i] = newPath + strings.TrimPrefix(folder, folderPath)
+		}
+	}
+	for i, fileMetadata := range v.FilesMetadata {
+		if isInFolder(fileMetadata.Folder, folderPath) {
+			v.FilesMetadata[i].Folder = newPath + strings.TrimPrefix(fileMetadata.Folder, folderPath)
+		}
+	}
+
+	sort.Strings(v.Folders)
+	return nil
+}
+
+// Checks no file or folder has the name in the folder
+func checkPathFree(v *Vault, folderPath string, name string) error {
+	vaultPath := joinVaultPath(folderPath, name)
+	if FolderExists(v, vaultPath) || findFile(v, vaultPath) != -1 {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, vaultPath)
+	}
+	return nil
+}
+
+// Returns the index of the file with the given path, or -1
+func findFile(v *Vault, vaultPath string) int64 {
+	for i, fileMetadata := range v.FilesMetadata {
+		if fileMetadata.Path() == vaultPath {
+			return int64(i)
+		}
+	}
+	return -1
+}
+
+// Normalizes a folder path and checks its elements
+func cleanFolderPath(folderPath string) (string, error) {
+	folderPath = strings.Trim(folderPath, folderSeparator)
+	for _, element := range splitFolderPath(folderPath) {
+		err := validateName(element)
+		if err != nil {
+			return "", err
+		}
+	}
+	return folderPath, nil
+}
+
+// Checks the name can be used for a file or a folder
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+func splitFolderPath(folderPath string) []string {
+	if folderPath == "" {
+		return nil
+	}
+	return strings.Split(folderPath, folderSeparator)
+}
+
+func joinVaultPath(folderPath string, name string) string {
+	if folderPath == "" {
+		return name
+	}
+	return folderPath + folderSeparator + name
+}
+
+func parentFolder(vaultPath string) string {
+	i := strings.LastIndex(vaultPath, folderSeparator)
+	if i == -1 {
+		return ""
+	}
+	return vaultPath[:i]
+}
+
+// Reports whether the path is the folder or inside it
+func isInFolder(vaultPath string, folderPath string) bool {
+	return folderPath == "" || vaultPath == folderPath || strings.HasPrefix(vaultPath, folderPath+folderSeparator)
+}
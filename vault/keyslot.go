@@ -0,0 +1,264 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"secure_vault/vault/utils"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultKeySlotLabel = "Default" // Label of the key slot created with the vault
+)
+
+// Factor is a bit set of the secrets a key slot requires
+type Factor uint8
+
+const (
+	FactorPassword Factor = 1 << iota // The key slot requires a password
+	FactorKeyfile                     // The key slot requires a keyfile
+)
+
+// Credentials holds the secrets that unlock a key slot
+type Credentials struct {
+	Password    string // Password, empty if not used
+	KeyfilePath string // Path of the keyfile, empty if not used
+}
+
+func (f Factor) String() string {
+	var names []string
+	if f&FactorPassword != 0 {
+		names = append(names, "Password")
+	}
+	if f&FactorKeyfile != 0 {
+		names = append(names, "Keyfile")
+	}
+	return strings.Join(names, " + ")
+}
+
+// Factors returns the factors the credentials provide
+func (c Credentials) Factors() Factor {
+	var factors Factor
+	if c.Password != "" {
+		factors |= FactorPassword
+	}
+	if c.KeyfilePath != "" {
+		factors |= FactorKeyfile
+	}
+	return factors
+}
+
+// Combines the credentials into the secret fed into the key derivation
+func (c Credentials) secret() ([]byte, error) {
+	// Check there is at least one factor
+	if c.Factors() == 0 {
+		return nil, fmt.Errorf("%w: password or keyfile is required", ErrInvalidArgument)
+	}
+
+	secret := []byte(c.Password)
+
+	// Prepend the keyfile hash to the password
+	if c.KeyfilePath != "" {
+		keyfileHash, err := utils.HashKeyfile(c.KeyfilePath)
+		if err != nil {
+			return nil, err
+		}
+		password := secret
+		secret = append(keyfileHash, password...)
+		utils.WipeKey(password)
+	}
+
+	return secret, nil
+}
+
+func AddKeySlot(v *Vault, key []byte, label string, credentials Credentials, kdfParams utils.KDFParams) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// Validation
+	if label == "" {
+		return fmt.Errorf("%w: key slot label is required", ErrInvalidArgument)
+	}
+	if findKeySlot(v, label) != -1 {
+		return fmt.Errorf("%w: %s", ErrKeySlotExists, label)
+	}
+
+	// Check the key derivation parameters
+	err := kdfParams.Validate()
+	if err != nil {
+		return err
+	}
+
+	// Wrap the master key for the new key slot
+	keySlot := KeySlot{
+		Label:     label,
+		KDFParams: kdfParams,
+		CreatedAt: time.Now().Truncate(0),
+	}
+	err = wrapMasterKey(v, &keySlot, key, credentials)
+	if err != nil {
+		return err
+	}
+
+	v.Metadata.KeySlots = append(v.Metadata.KeySlots, keySlot)
+	return nil
+}
+
+func ListKeySlots(v *Vault) []KeySlot {
+	keySlots := make([]KeySlot, len(v.Metadata.KeySlots))
+	copy(keySlots, v.Metadata.KeySlots)
+	return keySlots
+}
+
+func RevokeKeySlot(v *Vault, label string) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// If the key slot doesn't exist, return an error
+	slotIndex := findKeySlot(v, label)
+	if slotIndex == -1 {
+		return fmt.Errorf("%w: %s", ErrKeySlotNotFound, label)
+	}
+
+	// Keep at least one way to unlock the vault
+	if len(v.Metadata.KeySlots) == 1 {
+		return fmt.Errorf("%w: cannot revoke the last key slot", ErrInvalidArgument)
+	}
+
+	v.Metadata.KeySlots = append(v.Metadata.KeySlots[:slotIndex], v.Metadata.KeySlots[slotIndex+1:]...)
+	v.purgeBackups = true
+	return nil
+}
+
+func ChangePassword(v *Vault, oldPassword, newPassword string) error {
+	return ChangeCredentials(v, Credentials{Password: oldPassword}, Credentials{Password: newPassword})
+}
+
+func ChangeCredentials(v *Vault, oldCredentials, newCredentials Credentials) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// Find the key slot unlocked by the old credentials
+	slotIndex, key, err := unlockKeySlot(v, oldCredentials)
+	if err != nil {
+		return err
+	}
+	defer utils.WipeKey(key)
+
+	// Rewrap the master key with the new credentials, the files stay untouched
+	err = wrapMasterKey(v, &v.Metadata.KeySlots[slotIndex], key, newCredentials)
+	if err != nil {
+		return err
+	}
+	v.purgeBackups = true
+	return nil
+}
+
+func unlockMasterKey(v *Vault, credentials Credentials) ([]byte, error) {
+	_, key, err := unlockKeySlot(v, credentials)
+	return key, err
+}
+
+func unlockKeySlot(v *Vault, credentials Credentials) (int, []byte, error) {
+	// Combine the credentials into the secret
+	secret, err := credentials.secret()
+	if err != nil {
+		return -1, nil, err
+	}
+	defer utils.WipeKey(secret)
+
+	// Try the secret against every key slot requiring the same factors
+	for i := range v.Metadata.KeySlots {
+		keySlot := &v.Metadata.KeySlots[i]
+		if keySlot.Factors != credentials.Factors() {
+			continue
+		}
+
+		if len(keySlot.WrappedKey) == 0 {
+			key, err := unlockLegacyKeySlot(v, keySlot, secret)
+			return i, key, err
+		}
+
+		key, err := unwrapMasterKey(v, keySlot, secret)
+		if err == nil {
+			return i, key, nil
+		}
+
+		// A damaged key slot isn't reported as a wrong password
+		if errors.Is(err, ErrCorruptHeader) {
+			return -1, nil, err
+		}
+	}
+
+	return -1, nil, ErrWrongPassword
+}
+
+func wrapMasterKey(v *Vault, keySlot *KeySlot, key []byte, credentials Credentials) error {
+	// Combine the credentials into the secret
+	secret, err := credentials.secret()
+	if err != nil {
+		return err
+	}
+	defer utils.WipeKey(secret)
+
+	// Generate a fresh salt for the secret
+	salt, err := utils.GenerateSalt()
+	if err != nil {
+		return err
+	}
+
+	// Derive the wrapping key using the secret and salt
+	wrappingKey, err := deriveWrappingKey(secret, salt, keySlot.KDFParams)
+	if err != nil {
+		return err
+	}
+	defer utils.WipeKey(wrappingKey)
+
+	// Encrypt the master key
+	wrappedKey, err := utils.Encrypt(key, wrappingKey, v.Header.Cipher)
+	if err != nil {
+		return err
+	}
+
+	keySlot.Factors = credentials.Factors()
+	keySlot.Salt = salt
+	keySlot.WrappedKey = wrappedKey
+	return nil
+}
+
+func unwrapMasterKey(v *Vault, keySlot *KeySlot, secret []byte) ([]byte, error) {
+	// Check the key derivation parameters
+	err := keySlot.KDFParams.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptHeader, err)
+	}
+
+	// Derive the wrapping key using the secret and salt
+	wrappingKey, err := deriveWrappingKey(secret, keySlot.Salt, keySlot.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.WipeKey(wrappingKey)
+
+	// Decrypt the master key
+	return utils.Decrypt(keySlot.WrappedKey, wrappingKey, v.Header.Cipher)
+}
+
+func deriveWrappingKey(secret []byte, salt []byte, kdfParams utils.KDFParams) ([]byte, error) {
+	secretKey := utils.DeriveKey(secret, salt, kdfParams)
+	defer utils.WipeKey(secretKey)
+	return utils.DeriveSubkey(secretKey, utils.KeyWrapContext)
+}
+
+func findKeySlot(v *Vault, label string) int {
+	for i, keySlot := range v.Metadata.KeySlots {
+		if keySlot.Label == label {
+			return i
+		}
+	}
+	return -1
+}
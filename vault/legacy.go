@@ -0,0 +1,491 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"secure_vault/vault/utils"
+	"time"
+	"unsafe"
+)
+
+// Vault metadata of the single password format version
+type vaultMetadataV1 struct {
+	Salt       []byte          // Salt for key derivation
+	KDFParams  utils.KDFParams // Parameters for key derivation
+	WrappedKey []byte          // Master key encrypted with the password derived key
+	CreatedAt  time.Time       // Creation timestamp
+}
+
+func readVaultMetadataV1(vaultFile *os.File) (*VaultMetadata, error) {
+	// Decode the single password metadata
+	var metadataV1 vaultMetadataV1
+	err := readVaultMetadataInto(vaultFile, &metadataV1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vaults created before the parameters were stored use the legacy ones
+	if metadataV1.KDFParams == (utils.KDFParams{}) {
+		metadataV1.KDFParams = utils.LegacyKDFParams
+	}
+
+	// Convert the password into the default key slot
+	metadata := &VaultMetadata{
+		KeySlots: []KeySlot{
+			{
+				Label:      DefaultKeySlotLabel,
+				Factors:    FactorPassword,
+				Salt:       metadataV1.Salt,
+				KDFParams:  metadataV1.KDFParams,
+				WrappedKey: metadataV1.WrappedKey,
+				CreatedAt:  metadataV1.CreatedAt,
+			},
+		},
+		CreatedAt: metadataV1.CreatedAt,
+	}
+
+	return metadata, nil
+}
+
+// Vaults created before envelope encryption use the password key as the master key.
+// The password can't be verified here, LoadVault verifies it with the files metadata.
+func unlockLegacyKeySlot(v *Vault, keySlot *KeySlot, secret []byte) ([]byte, error) {
+	// Check the key derivation parameters
+	err := keySlot.KDFParams.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptHeader, err)
+	}
+
+	// Derive the password key, which is the master key
+	key := utils.DeriveKey(secret, keySlot.Salt, keySlot.KDFParams)
+
+	// Wrap the master key so the key slot is saved in the current format
+	wrappingKey, err := utils.DeriveSubkey(key, utils.KeyWrapContext)
+	if err != nil {
+		utils.WipeKey(key)
+		return nil, err
+	}
+	defer utils.WipeKey(wrappingKey)
+	keySlot.WrappedKey, err = utils.Encrypt(key, wrappingKey, v.Header.Cipher)
+	if err != nil {
+		utils.WipeKey(key)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Reports whether a key slot was created before envelope encryption
+func hasLegacyKeySlot(v *Vault) bool {
+	for _, keySlot := range v.Metadata.KeySlots {
+		if len(keySlot.WrappedKey) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Files added before chunking are encrypted as a single blob, which has to be read at once.
+func extractLegacyFile(v *Vault, key []byte, fileReader io.Reader, w io.Writer) error {
+	// Read the whole encrypted file content
+	fileData, err := io.ReadAll(fileReader)
+	if err != nil {
+		return err
+	}
+
+	// Decrypt the file content
+	file, err := utils.Decrypt(fileData, key, v.Header.Cipher)
+	if err != nil {
+		return err
+	}
+
+	// Write the decrypted file data
+	_, err = w.Write(file)
+	return err
+}
+
+/*
+	Vault file of the single password and key slots format versions has the following structure in disk:
+	Vault Header			VaultHeader (LittleEndian)
+	Vault Metadata Size 	int32 (LittleEndian)
+	Vault Metadata 			VaultMetadata
+	Files Metadata Size		int32 (LittleEndian)			[Encrypted, AEAD]
+	Files Metadata			[]FileMetadata					[Encrypted, AEAD]
+	Files					[]byte (dumped back to back)	[Encrypted]
+	Vault Integriy MAC		HMAC-SHA256 (keyed with a subkey of the vault key)
+*/
+
+func readFilesMetadataV2(vaultFile *os.File, key []byte, cipherSuite utils.CipherSuite) ([]FileMetadata, error) {
+	// Get the size overhead of the cipher
+	overhead, err := utils.Overhead(cipherSuite)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the size of the files metadata
+	encryptedFilesMetadataEncryptedSize := make([]byte, overhead+int(unsafe.Sizeof(int32(0))))
+	_, err = io.ReadFull(vaultFile, encryptedFilesMetadataEncryptedSize)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+
+	// Decrypt the size of the file metadata
+	encryptedFilesMetadataSizeBytes, err := utils.Decrypt(encryptedFilesMetadataEncryptedSize, key, cipherSuite)
+	if err != nil {
+		return nil, fmt.Errorf("%w: files metadata size can't be decrypted", ErrIntegrityMismatch)
+	}
+
+	// Decode the size of the file metadata
+	var encryptedFilesMetadataSize int32
+	err = utils.DecodeInt32FromBytes(encryptedFilesMetadataSizeBytes, &encryptedFilesMetadataSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check incorrect size
+	if encryptedFilesMetadataSize < 0 {
+		return nil, fmt.Errorf("%w: invalid files metadata size: %d", ErrIntegrityMismatch, encryptedFilesMetadataSize)
+	}
+
+	// Read the encrypted files metadata
+	encryptedFilesMetadata := make([]byte, encryptedFilesMetadataSize)
+	_, err = io.ReadFull(vaultFile, encryptedFilesMetadata)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+
+	// Decrypt the files metadata
+	filesMetadataBytes, err := utils.Decrypt(encryptedFilesMetadata, key, cipherSuite)
+	if err != nil {
+		return nil, fmt.Errorf("%w: files metadata can't be decrypted", ErrIntegrityMismatch)
+	}
+
+	// Decode the files metadata
+	var filesMetadata []FileMetadata
+	err = utils.DecodeDataFromBytes(filesMetadataBytes, &filesMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: files metadata can't be decoded: %v", ErrIntegrityMismatch, err)
+	}
+
+	// Files start right after the files metadata
+	filesOffset, err := vaultFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := vaultFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	filesEndOffset := stat.Size() - int64(utils.HashSize)
+
+	for i := range filesMetadata {
+		// Files saved before their encrypted size was stored end where the next one starts
+		if filesMetadata[i].EncryptedSize == 0 {
+			fileEndOffset := filesEndOffset
+			if i+1 < len(filesMetadata) {
+				fileEndOffset = filesOffset + filesMetadata[i+1].Offset
+			}
+			filesMetadata[i].EncryptedSize = fileEndOffset - filesOffset - filesMetadata[i].Offset
+		}
+
+		// Offsets were relative to the start of the files
+		filesMetadata[i].Offset += filesOffset
+	}
+
+	return filesMetadata, nil
+}
+
+func checkVaultHashV2(vaultFile *os.File, key []byte) (bool, error) {
+	// Load the vault hash
+	expectedVaultHash, err := readVaultHash(vaultFile)
+	if err != nil {
+		return false, err
+	}
+
+	// Derive the vault MAC key
+	macKey, err := utils.DeriveSubkey(key, utils.VaultMACContext)
+	if err != nil {
+		return false, err
+	}
+
+	// Verify the data integrity by recomputing the MAC
+	vaultHash, err := utils.GenerateFileMAC(vaultFile, macKey, 0, -utils.HashSize, io.SeekStart, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(vaultHash, expectedVaultHash), nil
+}
+
+func readVaultHash(vaultFile *os.File) ([]byte, error) {
+	// Save the current file pointer position
+	currentPos, err := vaultFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the size of the data
+	stat, err := vaultFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	totalVaultSize := stat.Size()
+	dataSize := totalVaultSize - int64(utils.HashSize)
+
+	// Seek to the start of the hash part
+	_, err = vaultFile.Seek(dataSize, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the vault hash
+	vaultHash := make([]byte, int64(utils.HashSize))
+	_, err = io.ReadFull(vaultFile, vaultHash)
+	if err != nil {
+		return nil, truncatedError(err)
+	}
+
+	// Restore the file pointer to its original position
+	_, err = vaultFile.Seek(currentPos, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return vaultHash, nil
+}
+
+/*
+	Vault file of the original format version has the following structure in disk:
+	Vault Metadata Size 	int32 (LittleEndian)
+	Vault Metadata 			vaultMetadataV0
+	Files Metadata Size		int32 (LittleEndian)			[Encrypted, AES-CTR]
+	Files Metadata			[]fileMetadataV0				[Encrypted, AES-CTR]
+	Files					[]byte (dumped back to back)	[Encrypted, AES-CTR]
+	Vault Integriy Hash		SHA256
+
+	Nothing is authenticated, so the files are re-encrypted into the staging
+	file when the vault is loaded and the next save writes the current format.
+*/
+
+// Vault metadata of the original format version
+type vaultMetadataV0 struct {
+	Salt      []byte    // Salt for key derivation
+	CreatedAt time.Time // Creation timestamp
+}
+
+// File metadata of the original format version
+type fileMetadataV0 struct {
+	Name          string    // Filename
+	Index         int64     // Index in the vault
+	Offset        int64     // Offset from the start of the files
+	IntegrityHash []byte    // SHA256 of the encrypted file
+	AddedAt       time.Time // Timestamp when the file was added
+}
+
+func readVaultV0(vaultFile *os.File, credentials Credentials) (*Vault, []byte, error) {
+	// Without a header the vault is only recognized by its metadata
+	metadata, err := readVaultMetadataV0(vaultFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The vault hash isn't keyed, so it is verified before the password
+	integrity, err := checkVaultHashV0(vaultFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !integrity {
+		return nil, nil, fmt.Errorf("%w: vault hash does not match", ErrIntegrityMismatch)
+	}
+
+	// Reconstruct the vault structure, the files are re-encrypted with the current cipher
+	v := &Vault{
+		Header: VaultHeader{
+			Version: FormatVersion0,
+			Cipher:  utils.DefaultCipherSuite,
+			KDF:     utils.KDFArgon2id,
+		},
+		Metadata: *metadata,
+	}
+
+	// The password key is the master key
+	key, err := unlockMasterKey(v, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Load files metadata, a wrong key yields garbage instead of an error
+	filesMetadata, filesOffset, err := readFilesMetadataV0(vaultFile, key)
+	if err != nil {
+		utils.WipeKey(key)
+		return nil, nil, err
+	}
+
+	// Re-encrypt the files into the staging file
+	err = migrateFilesV0(v, key, vaultFile, filesMetadata, filesOffset)
+	if err != nil {
+		closeStagingFile(v)
+		utils.WipeKey(key)
+		return nil, nil, err
+	}
+
+	// Changes are tracked from the loaded state
+	err = markSaved(v)
+	if err != nil {
+		utils.WipeKey(key)
+		return nil, nil, err
+	}
+
+	return v, key, nil
+}
+
+func readVaultMetadataV0(vaultFile *os.File) (*VaultMetadata, error) {
+	_, err := vaultFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything that doesn't decode as the original metadata isn't a vault
+	var metadataV0 vaultMetadataV0
+	err = readVaultMetadataInto(vaultFile, &metadataV0)
+	if err != nil || len(metadataV0.Salt) == 0 {
+		return nil, ErrNotVault
+	}
+
+	// Convert the password into the default key slot
+	metadata := &VaultMetadata{
+		KeySlots: []KeySlot{
+			{
+				Label:     DefaultKeySlotLabel,
+				Factors:   FactorPassword,
+				Salt:      metadataV0.Salt,
+				KDFParams: utils.LegacyKDFParams,
+				CreatedAt: metadataV0.CreatedAt,
+			},
+		},
+		CreatedAt: metadataV0.CreatedAt,
+	}
+
+	return metadata, nil
+}
+
+func checkVaultHashV0(vaultFile *os.File) (bool, error) {
+	// Check this is a vault of the original format
+	_, err := readVaultMetadataV0(vaultFile)
+	if err != nil {
+		return false, err
+	}
+
+	// Load the vault hash
+	expectedVaultHash, err := readVaultHash(vaultFile)
+	if err != nil {
+		return false, err
+	}
+
+	// Verify the data integrity by recomputing the hash
+	vaultHash, err := utils.GenerateFileHash(vaultFile, 0, -utils.HashSize, io.SeekStart, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	return hmac.Equal(vaultHash, expectedVaultHash), nil
+}
+
+// Reads the files metadata that follows the vault metadata, returns it with the offset of the files
+func readFilesMetadataV0(vaultFile *os.File, key []byte) ([]fileMetadataV0, int64, error) {
+	// The files end where the vault hash starts
+	stat, err := vaultFile.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	filesEndOffset := stat.Size() - int64(utils.HashSize)
+
+	// Read and decrypt the size of the files metadata
+	sizeReader, err := utils.NewLegacyDecryptReader(io.LimitReader(vaultFile, int64(aes.BlockSize+4)), key)
+	if err != nil {
+		return nil, 0, truncatedError(err)
+	}
+	var filesMetadataSize int32
+	err = binary.Read(sizeReader, binary.LittleEndian, &filesMetadataSize)
+	if err != nil {
+		return nil, 0, truncatedError(err)
+	}
+
+	// A wrong key shows up as a size that doesn't fit in the file
+	position, err := vaultFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	if filesMetadataSize < aes.BlockSize || int64(filesMetadataSize) > filesEndOffset-position {
+		return nil, 0, ErrWrongPassword
+	}
+
+	// Read and decrypt the files metadata
+	filesMetadataReader, err := utils.NewLegacyDecryptReader(io.LimitReader(vaultFile, int64(filesMetadataSize)), key)
+	if err != nil {
+		return nil, 0, truncatedError(err)
+	}
+	filesMetadataBytes, err := io.ReadAll(filesMetadataReader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Or as files metadata that doesn't decode
+	var filesMetadata []fileMetadataV0
+	err = utils.DecodeDataFromBytes(filesMetadataBytes, &filesMetadata)
+	if err != nil {
+		return nil, 0, ErrWrongPassword
+	}
+
+	// Check the files lie back to back inside the file
+	filesOffset := position + int64(filesMetadataSize)
+	previousOffset := int64(0)
+	for _, fileMetadata := range filesMetadata {
+		if fileMetadata.Offset < previousOffset || filesOffset+fileMetadata.Offset+aes.BlockSize > filesEndOffset {
+			return nil, 0, fmt.Errorf("%w: invalid file offset: %d", ErrIntegrityMismatch, fileMetadata.Offset)
+		}
+		previousOffset = fileMetadata.Offset + aes.BlockSize
+	}
+
+	return filesMetadata, filesOffset, nil
+}
+
+func migrateFilesV0(v *Vault, key []byte, vaultFile *os.File, filesMetadata []fileMetadataV0, filesOffset int64) error {
+	stat, err := vaultFile.Stat()
+	if err != nil {
+		return err
+	}
+	filesEndOffset := stat.Size() - int64(utils.HashSize)
+
+	for i, fileMetadata := range filesMetadata {
+		// Files end where the next one starts
+		fileEndOffset := filesEndOffset
+		if i+1 < len(filesMetadata) {
+			fileEndOffset = filesOffset + filesMetadata[i+1].Offset
+		}
+		fileOffset := filesOffset + fileMetadata.Offset
+		fileReader, err := utils.NewLegacyDecryptReader(io.NewSectionReader(vaultFile, fileOffset, fileEndOffset-fileOffset), key)
+		if err != nil {
+			return truncatedError(err)
+		}
+
+		// The original format allowed the same name twice
+		name := fileMetadata.Name
+		for number := 1; findFile(v, name) != -1; number++ {
+			name = numberedPath(fileMetadata.Name, number)
+		}
+
+		// Encrypt the file again with the current format
+		err = addStream(v, key, "", name, fileReader, FileAttributes{})
+		if err != nil {
+			return err
+		}
+		v.FilesMetadata[len(v.FilesMetadata)-1].AddedAt = fileMetadata.AddedAt
+	}
+
+	return nil
+}
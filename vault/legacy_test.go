@@ -0,0 +1,219 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"secure_vault/vault/utils"
+	"testing"
+	"time"
+)
+
+// testdata/baseline.vault was written by the original format version with the
+// password "password", it holds hello.txt and data.bin
+func copyBaselineVault(t *testing.T) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "baseline.vault"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultPath := filepath.Join(t.TempDir(), "baseline.vault")
+	err = os.WriteFile(vaultPath, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vaultPath
+}
+
+func baselineContents() map[string][]byte {
+	data := make([]byte, 20000)
+	for i := range data {
+		data[i] = byte(i * 31 % 251)
+	}
+	return map[string][]byte{
+		"hello.txt": []byte("hello from the baseline format\n"),
+		"data.bin":  data,
+	}
+}
+
+func checkBaselineFiles(t *testing.T, v *Vault, key []byte) {
+	t.Helper()
+
+	expected := baselineContents()
+	if len(v.FilesMetadata) != len(expected) {
+		t.Fatalf("got %d files, want %d", len(v.FilesMetadata), len(expected))
+	}
+	for _, fileMetadata := range v.FilesMetadata {
+		var buf bytes.Buffer
+		err := ExtractFileToWriter(v, key, fileMetadata.ID, &buf)
+		if err != nil {
+			t.Fatalf("extract %s: %v", fileMetadata.Name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected[fileMetadata.Name]) {
+			t.Errorf("content of %s does not match", fileMetadata.Name)
+		}
+	}
+}
+
+func TestLoadBaselineVault(t *testing.T) {
+	vaultPath := copyBaselineVault(t)
+
+	v, key, err := LoadVault(Credentials{Password: "password"}, vaultPath, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseVault(v)
+
+	if v.Header.Version != FormatVersion0 {
+		t.Errorf("got format version %d, want %d", v.Header.Version, FormatVersion0)
+	}
+	checkBaselineFiles(t, v, key)
+
+	integrity, err := CheckVaultIntegrity(vaultPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !integrity {
+		t.Error("integrity check of the baseline vault failed")
+	}
+}
+
+func TestLoadBaselineVaultWrongPassword(t *testing.T) {
+	vaultPath := copyBaselineVault(t)
+
+	_, _, err := LoadVault(Credentials{Password: "wrong"}, vaultPath, LoadOptions{ReadOnly: true})
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("got %v, want %v", err, ErrWrongPassword)
+	}
+}
+
+func TestLoadBaselineVaultDamaged(t *testing.T) {
+	vaultPath := copyBaselineVault(t)
+
+	// Flip a byte of the last file
+	data, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-100] ^= 0xff
+	err = os.WriteFile(vaultPath, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = LoadVault(Credentials{Password: "password"}, vaultPath, LoadOptions{ReadOnly: true})
+	if !errors.Is(err, ErrIntegrityMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrIntegrityMismatch)
+	}
+}
+
+func TestMigrateBaselineVault(t *testing.T) {
+	vaultPath := copyBaselineVault(t)
+
+	v, key, err := LoadVault(Credentials{Password: "password"}, vaultPath, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveVault(v, key, vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved vault is in the current format
+	v, key, err = LoadVault(Credentials{Password: "password"}, vaultPath, LoadOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseVault(v)
+
+	if v.Header.Version != CurrentFormatVersion {
+		t.Errorf("got format version %d, want %d", v.Header.Version, CurrentFormatVersion)
+	}
+	checkBaselineFiles(t, v, key)
+}
+
+// Encodes the vault as an empty vault of format version 2, its files metadata
+// is followed by the MAC over the whole file
+func encodeTestVaultV2(t *testing.T, v *Vault, key []byte) []byte {
+	t.Helper()
+
+	header := v.Header
+	header.Version = FormatVersion2
+	var buf bytes.Buffer
+	err := writeVaultHeader(&buf, &header)
+	if err == nil {
+		err = writeVaultMetadata(&buf, &v.Metadata)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The size of the files metadata and the files metadata are encrypted apart
+	filesMetadataBytes, err := utils.EncodeDataToBytes([]FileMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedFilesMetadata, err := utils.Encrypt(filesMetadataBytes, key, header.Cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeBytes, err := utils.EncodeInt32ToBytes(int32(len(encryptedFilesMetadata)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSize, err := utils.Encrypt(sizeBytes, key, header.Cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(encryptedSize)
+	buf.Write(encryptedFilesMetadata)
+
+	// MAC over everything before it
+	macKey, err := utils.DeriveSubkey(key, utils.VaultMACContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(buf.Bytes())
+	buf.Write(mac.Sum(nil))
+	return buf.Bytes()
+}
+
+func TestLoadVaultV2ChecksMAC(t *testing.T) {
+	v, key, err := CreateVault(testCredentials, utils.DefaultCipherSuite, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultPath := filepath.Join(t.TempDir(), "v2.vault")
+	data := encodeTestVaultV2(t, v, key)
+	err = os.WriteFile(vaultPath, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := loadTestVault(t, vaultPath)
+	if loaded.Header.Version != FormatVersion2 {
+		t.Fatalf("got format version %d, want %d", loaded.Header.Version, FormatVersion2)
+	}
+	CloseVault(loaded)
+
+	// Metadata changed without the key fails the MAC, though the encrypted parts are intact
+	v.Metadata.CreatedAt = v.Metadata.CreatedAt.Add(-time.Hour)
+	tampered := encodeTestVaultV2(t, v, key)
+	copy(tampered[len(tampered)-utils.HashSize:], data[len(data)-utils.HashSize:])
+	err = os.WriteFile(vaultPath, tampered, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = LoadVault(testCredentials, vaultPath, LoadOptions{ReadOnly: true})
+	if !errors.Is(err, ErrIntegrityMismatch) {
+		t.Errorf("got %v, want %v", err, ErrIntegrityMismatch)
+	}
+}
@@ -0,0 +1,122 @@
+package vault
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+	A vault open for writing holds an exclusive advisory lock on a lock file
+	next to it, so two writers never save over each other. The lock isn't
+	taken on the vault file itself because rewrites rename a new file over it.
+	The lock file records the process holding the lock for the error of the
+	others, and is left in place when the lock is released: removing it could
+	let a waiting process lock the removed file while another locks a new one.
+	Read-only opens don't take the lock.
+*/
+
+// Suffix of the lock file next to the vault
+const lockSuffix = ".lock"
+
+// VaultInUseError is returned when another process holds the write lock of a vault
+type VaultInUseError struct {
+	Path string // Path of the vault
+	PID  int    // Process holding the lock, 0 if unknown
+	Host string // Host the process runs on, empty if unknown
+}
+
+func (e *VaultInUseError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("vault is in use by another process: %s", e.Path)
+	}
+	if e.Host == "" {
+		return fmt.Sprintf("vault is in use by PID %d: %s", e.PID, e.Path)
+	}
+	return fmt.Sprintf("vault is in use by PID %d on host %s: %s", e.PID, e.Host, e.Path)
+}
+
+// Takes the write lock of the vault, fails with a VaultInUseError if another process holds it
+func lockVault(vaultPath string) (*os.File, error) {
+	// Open the lock file, creating it on the first lock
+	lockFile, err := os.OpenFile(vaultPath+lockSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take the lock without waiting for its holder
+	locked, err := tryLockFile(lockFile)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	if !locked {
+		pid, host := readLockOwner(lockFile)
+		lockFile.Close()
+		return nil, &VaultInUseError{Path: vaultPath, PID: pid, Host: host}
+	}
+
+	// Record this process as the holder
+	err = writeLockOwner(lockFile)
+	if err != nil {
+		unlockVault(lockFile)
+		return nil, err
+	}
+
+	return lockFile, nil
+}
+
+// Releases the write lock of the vault, if held
+func unlockVault(lockFile *os.File) error {
+	if lockFile == nil {
+		return nil
+	}
+
+	// Forget the holder, a stale one would show up in the errors of the others
+	lockFile.Truncate(0)
+
+	err := unlockFile(lockFile)
+	closeErr := lockFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeLockOwner(lockFile *os.File) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	err = lockFile.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = lockFile.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), host)), 0)
+	return err
+}
+
+// Reads the holder recorded in the lock file, zero values if it can't be read
+func readLockOwner(lockFile *os.File) (int, string) {
+	_, err := lockFile.Seek(0, 0)
+	if err != nil {
+		return 0, ""
+	}
+
+	// The PID and the host are on their own lines
+	scanner := bufio.NewScanner(lockFile)
+	if !scanner.Scan() {
+		return 0, ""
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		return 0, ""
+	}
+	if !scanner.Scan() {
+		return pid, ""
+	}
+	return pid, strings.TrimSpace(scanner.Text())
+}
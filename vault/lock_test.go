@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadVaultInUse(t *testing.T) {
+	v, _, vaultPath := createTestVault(t)
+
+	// The vault is held by the first writer
+	_, _, err := LoadVault(testCredentials, vaultPath, LoadOptions{})
+	var inUseErr *VaultInUseError
+	if !errors.As(err, &inUseErr) {
+		t.Fatalf("got %v, want a VaultInUseError", err)
+	}
+	if inUseErr.PID != os.Getpid() {
+		t.Errorf("got holder PID %d, want %d", inUseErr.PID, os.Getpid())
+	}
+
+	// Readers don't need the lock
+	reader, _, err := LoadVault(testCredentials, vaultPath, LoadOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CloseVault(reader)
+
+	// The lock is released with the vault
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadTestVault(t, vaultPath)
+}
+
+func TestLoadVaultLeavesNoStrayLockFile(t *testing.T) {
+	folderPath := t.TempDir()
+	textPath := filepath.Join(folderPath, "notes.txt")
+	err := os.WriteFile(textPath, []byte("not a vault, long enough to hold a header"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only vaults get a lock file next to them
+	for _, vaultPath := range []string{filepath.Join(folderPath, "missing.vault"), textPath} {
+		_, _, err = LoadVault(testCredentials, vaultPath, LoadOptions{})
+		if err == nil {
+			t.Fatalf("%s loaded", filepath.Base(vaultPath))
+		}
+		_, err = os.Stat(vaultPath + lockSuffix)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("lock file left next to %s: %v", filepath.Base(vaultPath), err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyRejectsChanges(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	fileID := addTestFile(t, v, key, "a.txt", []byte("a"))
+	err := CreateFolder(v, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	saved := readTestVaultFile(t, vaultPath)
+
+	reader, readerKey, err := LoadVault(testCredentials, vaultPath, LoadOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseVault(reader)
+	if !IsReadOnly(reader) {
+		t.Fatal("vault not reported read-only")
+	}
+
+	// Every change fails before touching the vault
+	changes := map[string]func() error{
+		"AddStreamToVault": func() error {
+			return AddStreamToVault(reader, readerKey, "", "b.txt", strings.NewReader("b"))
+		},
+		"RemoveFileFromVault": func() error { return RemoveFileFromVault(reader, fileID) },
+		"TakeFile": func() error {
+			_, err := TakeFile(reader, readerKey, fileID, t.TempDir(), ExportOptions{})
+			return err
+		},
+		"CreateFolder": func() error { return CreateFolder(reader, "other") },
+		"RenameFolder": func() error { return RenameFolder(reader, "docs", "renamed") },
+		"MoveFolder":   func() error { return MoveFolder(reader, "docs", "") },
+		"DeleteFolder": func() error { return DeleteFolder(reader, "docs", true) },
+		"MoveFile":     func() error { return MoveFile(reader, fileID, "docs") },
+		"AddKeySlot": func() error {
+			return AddKeySlot(reader, readerKey, "other", Credentials{Password: "other"}, testKDFParams)
+		},
+		"RevokeKeySlot":  func() error { return RevokeKeySlot(reader, "other") },
+		"ChangePassword": func() error { return ChangePassword(reader, "password", "changed") },
+		"ImportFolder": func() error {
+			_, err := ImportFolder(reader, readerKey, t.TempDir(), "", TransferOptions{})
+			return err
+		},
+		"SaveVault":     func() error { return SaveVault(reader, readerKey, vaultPath) },
+		"CompactVault":  func() error { return CompactVault(reader, readerKey) },
+		"RestoreBackup": func() error { return RestoreBackup(reader, readerKey, vaultPath) },
+	}
+	for name, change := range changes {
+		err = change()
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	if !bytes.Equal(readTestVaultFile(t, vaultPath), saved) {
+		t.Error("read-only vault was modified")
+	}
+	checkTestFiles(t, reader, readerKey, map[string][]byte{fileID: []byte("a")})
+}
+
+func TestReadOnlyRefusesUnfinishedSave(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	addTestFile(t, v, key, "lost.txt", []byte("lost"))
+	crashMidAppend(t, v, key)
+
+	// Only a writer can roll the append back
+	_, _, err := LoadVault(testCredentials, vaultPath, LoadOptions{ReadOnly: true})
+	if !errors.Is(err, ErrUnfinishedSave) {
+		t.Fatalf("got %v, want ErrUnfinishedSave", err)
+	}
+}
@@ -0,0 +1,156 @@
+package vault
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+	Saves never leave the vault file half written:
+	- A rewrite goes into a temporary file next to the vault, which is synced,
+	  verified and renamed over the vault, then the folder is synced.
+	- An append first records the size of the vault file in a journal next to
+	  the vault. The journal is removed once the new segment is synced and
+	  verified, a journal left behind by a crash rolls the vault file back.
+*/
+
+const (
+	tempSuffix    = ".tmp"     // Suffix of the temporary files written on rewrite
+	journalSuffix = ".journal" // Suffix of the journal written on append
+)
+
+// RecoverVault rolls back an interrupted append and removes the temporary
+// files left behind by an interrupted rewrite of the vault.
+func RecoverVault(vaultPath string) error {
+	// Roll back the vault file to its size before the append
+	err := rollbackJournal(vaultPath)
+	if err != nil {
+		return err
+	}
+
+	// Remove the stale temporary files
+	tempPaths, err := filepath.Glob(tempPattern(vaultPath))
+	if err != nil {
+		return err
+	}
+	for _, tempPath := range tempPaths {
+		err = os.Remove(tempPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pattern of the temporary files of a vault, also accepted by os.CreateTemp
+func tempPattern(vaultPath string) string {
+	return filepath.Join(filepath.Dir(vaultPath), filepath.Base(vaultPath)+".*"+tempSuffix)
+}
+
+func writeJournal(vaultPath string, vaultSize int64) error {
+	// Create the journal
+	journalFile, err := os.OpenFile(vaultPath+journalSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Record the size of the vault file before the append
+	err = binary.Write(journalFile, binary.LittleEndian, vaultSize)
+	if err == nil {
+		err = journalFile.Sync()
+	}
+
+	closeErr := journalFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(vaultPath + journalSuffix)
+		return err
+	}
+
+	// Make the journal itself durable
+	return syncDir(filepath.Dir(vaultPath))
+}
+
+func removeJournal(vaultPath string) error {
+	err := os.Remove(vaultPath + journalSuffix)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(vaultPath))
+}
+
+func rollbackJournal(vaultPath string) error {
+	// Read the journal, if there is one
+	journalFile, err := os.Open(vaultPath + journalSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var vaultSize int64
+	err = binary.Read(journalFile, binary.LittleEndian, &vaultSize)
+	journalFile.Close()
+
+	// A partial journal was written before the append started, nothing to roll back
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return removeJournal(vaultPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Check the recorded size
+	stat, err := os.Stat(vaultPath)
+	if err != nil {
+		return err
+	}
+	if vaultSize < 0 || vaultSize > stat.Size() {
+		return fmt.Errorf("%w: %s", ErrCorruptJournal, vaultPath+journalSuffix)
+	}
+
+	// Drop the partially appended segment
+	err = truncateFile(vaultPath, vaultSize)
+	if err != nil {
+		return err
+	}
+
+	return removeJournal(vaultPath)
+}
+
+func truncateFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = file.Truncate(size)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func verifyVaultFile(vaultPath string, key []byte) error {
+	integrity, err := CheckVaultIntegrity(vaultPath, key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+	}
+	if !integrity {
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, ErrIntegrityMismatch)
+	}
+	return nil
+}
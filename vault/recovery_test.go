@@ -0,0 +1,127 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Appends the staged files of the vault like a save that crashes once half of
+// the segment reached the disk, leaving the journal behind
+func crashMidAppend(t *testing.T, v *Vault, key []byte) {
+	t.Helper()
+
+	stat, err := os.Stat(v.vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentOffset := stat.Size()
+
+	err = writeJournal(v.vaultPath, segmentOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesMetadata := make([]FileMetadata, len(v.FilesMetadata))
+	copy(filesMetadata, v.FilesMetadata)
+	err = appendSegment(v, key, segmentOffset, filesMetadata, v.Tombstones)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only half of the segment was written
+	stat, err = os.Stat(v.vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(v.vaultPath, segmentOffset+(stat.Size()-segmentOffset)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process is gone, and its lock with it
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverInterruptedAppend(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	savedID := addTestFile(t, v, key, "saved.txt", []byte("saved"))
+	saveTestVault(t, v, key, vaultPath)
+	saved := readTestVaultFile(t, vaultPath)
+
+	addTestFile(t, v, key, "lost.txt", bytes.Repeat([]byte("lost"), 10000))
+	crashMidAppend(t, v, key)
+
+	// Loading rolls the vault back to the last save
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, map[string][]byte{savedID: []byte("saved")})
+	if !bytes.Equal(readTestVaultFile(t, vaultPath), saved) {
+		t.Error("vault file differs from the last save after recovery")
+	}
+	_, err := os.Stat(vaultPath + journalSuffix)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal left after recovery: %v", err)
+	}
+
+	// The recovered vault takes new saves
+	addedID := addTestFile(t, v, key, "added.txt", []byte("added"))
+	saveTestVault(t, v, key, vaultPath)
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, map[string][]byte{savedID: []byte("saved"), addedID: []byte("added")})
+}
+
+func TestRecoverVault(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	savedID := addTestFile(t, v, key, "saved.txt", []byte("saved"))
+	saveTestVault(t, v, key, vaultPath)
+	saved := readTestVaultFile(t, vaultPath)
+
+	addTestFile(t, v, key, "lost.txt", []byte("lost"))
+	crashMidAppend(t, v, key)
+
+	// A rewrite crashed before renaming its temporary file as well
+	tempFile, err := os.CreateTemp(filepath.Dir(vaultPath), filepath.Base(tempPattern(vaultPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile.WriteString("partial rewrite")
+	tempFile.Close()
+
+	err = RecoverVault(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readTestVaultFile(t, vaultPath), saved) {
+		t.Error("vault file differs from the last save after recovery")
+	}
+	_, err = os.Stat(tempFile.Name())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("temporary file left after recovery: %v", err)
+	}
+
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, map[string][]byte{savedID: []byte("saved")})
+}
+
+func TestRecoverUnchangedVault(t *testing.T) {
+	_, _, vaultPath := createTestVault(t)
+	saved := readTestVaultFile(t, vaultPath)
+
+	// Nothing to recover
+	err := RecoverVault(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readTestVaultFile(t, vaultPath), saved) {
+		t.Error("recovery changed a vault that was saved completely")
+	}
+}
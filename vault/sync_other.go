@@ -0,0 +1,16 @@
+//go:build !windows
+
+package vault
+
+import "os"
+
+// Makes the renames and removals in a folder durable
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
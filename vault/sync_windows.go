@@ -0,0 +1,8 @@
+//go:build windows
+
+package vault
+
+// Folders can't be synced on Windows, renames are durable once they return
+func syncDir(dirPath string) error {
+	return nil
+}
@@ -0,0 +1,352 @@
+package vault
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides what happens to the symbolic links found while importing a folder
+type SymlinkPolicy uint8
+
+const (
+	SymlinkSkip   SymlinkPolicy = iota // Symbolic links are left out
+	SymlinkFollow                      // The targets of symbolic links are imported
+)
+
+// TransferOptions selects the files imported or exported with a folder.
+// Patterns are matched against the slash separated paths relative to the
+// folder, patterns without a slash are matched against the names only.
+type TransferOptions struct {
+	Include  []string      // Patterns of the files to transfer, every file if empty
+	Exclude  []string      // Patterns of the files and folders to leave out
+	Symlinks SymlinkPolicy // Symbolic link policy of imports
+
+	SkipAttributes Attributes // File attributes neither recorded nor restored
+	RestoreOwner   bool       // Restores the recorded owners of exported files
+
+	Conflict ConflictPolicy                       // What happens when an exported file already exists
+	Resolve  func(filePath string) ConflictPolicy // Chooses the policy of each conflict with ConflictAsk
+}
+
+// TransferResult is the outcome of importing or exporting a single file
+type TransferResult struct {
+	Path    string // Path relative to the transferred folder, folders end with a slash
+	Size    int64  // Size of the file content in bytes
+	Target  string // Path of the exported file on the disk, renamed on conflicts
+	Skipped bool   // Left out by the patterns, the symbolic link policy or the conflict policy
+	Err     error  // Why the file couldn't be transferred
+}
+
+// TransferSummary collects the results of a folder import or export
+type TransferSummary struct {
+	Results     []TransferResult
+	Transferred int   // Number of files transferred
+	Skipped     int   // Number of files and folders left out
+	Failed      int   // Number of files and folders that couldn't be transferred
+	Bytes       int64 // Size of the transferred files in bytes
+}
+
+func (s *TransferSummary) String() string {
+	return fmt.Sprintf("%d transferred (%d bytes), %d skipped, %d failed", s.Transferred, s.Bytes, s.Skipped, s.Failed)
+}
+
+func (s *TransferSummary) add(result TransferResult) {
+	switch {
+	case result.Err != nil:
+		s.Failed++
+	case result.Skipped:
+		s.Skipped++
+	default:
+		s.Transferred++
+		s.Bytes += result.Size
+	}
+	s.Results = append(s.Results, result)
+}
+
+// ImportFolder adds the folder on the disk with its content into the vault folder,
+// keeping the relative paths of the files. Files that can't be added are
+// reported in the summary, the import goes on with the others.
+func ImportFolder(v *Vault, key []byte, dirPath string, folderPath string, options TransferOptions) (*TransferSummary, error) {
+	if v.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Validation
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+	folderPath, err = cleanFolderPath(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if !FolderExists(v, folderPath) {
+		return nil, fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+	stat, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%w: not a folder: %s", ErrInvalidArgument, dirPath)
+	}
+
+	// The imported folder is created inside the vault folder
+	rootPath := joinVaultPath(folderPath, filepath.Base(filepath.Clean(dirPath)))
+	err = checkPathFree(v, folderPath, path.Base(rootPath))
+	if err != nil {
+		return nil, err
+	}
+	err = CreateFolder(v, rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TransferSummary{}
+	err = importDir(v, key, dirPath, rootPath, "", options, summary, map[string]bool{})
+	if err != nil {
+		return summary, err
+	}
+
+	return summary, nil
+}
+
+// ExportFolder writes decrypted copies of the vault folder with its content
+// into the folder on the disk, recreating the folder tree. The root folder's
+// content is written directly into the export folder.
+func ExportFolder(v *Vault, key []byte, folderPath string, exportFolderPath string, options TransferOptions) (*TransferSummary, error) {
+	// Validation
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+	folderPath, err = cleanFolderPath(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if !FolderExists(v, folderPath) {
+		return nil, fmt.Errorf("%w: %s", ErrFolderNotFound, folderPath)
+	}
+
+	// Create the folder on the disk
+	targetPath := exportFolderPath
+	if folderPath != "" {
+		targetPath = filepath.Join(exportFolderPath, path.Base(folderPath))
+	}
+	err = os.MkdirAll(targetPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TransferSummary{}
+
+	// Recreate the subfolders, so empty ones are exported too
+	for _, folder := range v.Folders {
+		if folder == folderPath || !isInFolder(folder, folderPath) {
+			continue
+		}
+
+		// Paths of crafted vaults could point outside the export folder
+		relativePath := relativeVaultPath(folder, folderPath)
+		_, err = cleanFolderPath(folder)
+		if err != nil {
+			summary.add(TransferResult{Path: relativePath + folderSeparator, Err: err})
+			continue
+		}
+
+		if options.excluded(relativePath) {
+			if !options.excluded(parentFolder(relativePath)) {
+				summary.add(TransferResult{Path: relativePath + folderSeparator, Skipped: true})
+			}
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Join(targetPath, filepath.FromSlash(relativePath)), 0700)
+		if err != nil {
+			summary.add(TransferResult{Path: relativePath + folderSeparator, Err: err})
+		}
+	}
+
+	// Export the files into their folders
+	exportOptions := ExportOptions{
+		SkipAttributes: options.SkipAttributes,
+		RestoreOwner:   options.RestoreOwner,
+		Conflict:       options.Conflict,
+		Resolve:        options.Resolve,
+	}
+	for _, fileMetadata := range v.FilesMetadata {
+		if !isInFolder(fileMetadata.Folder, folderPath) {
+			continue
+		}
+
+		relativePath := relativeVaultPath(fileMetadata.Path(), folderPath)
+		if options.excluded(parentFolder(relativePath)) {
+			continue
+		}
+		if options.excluded(relativePath) || !options.included(relativePath) {
+			summary.add(TransferResult{Path: relativePath, Skipped: true})
+			continue
+		}
+
+		result := TransferResult{Path: relativePath, Size: fileMetadata.Size}
+		_, result.Err = cleanFolderPath(fileMetadata.Folder)
+		if result.Err == nil {
+			outputFolderPath := filepath.Join(targetPath, filepath.FromSlash(parentFolder(relativePath)))
+			result.Err = os.MkdirAll(outputFolderPath, 0700)
+			if result.Err == nil {
+				result.Target, result.Err = ExportFile(v, key, fileMetadata.ID, outputFolderPath, exportOptions)
+				result.Skipped = result.Target == ""
+			}
+		}
+		summary.add(result)
+	}
+
+	return summary, nil
+}
+
+func importDir(v *Vault, key []byte, dirPath string, vaultFolder string, relativeDir string,
+	options TransferOptions, summary *TransferSummary, visited map[string]bool) error {
+	// Guard against symbolic links pointing to a parent folder
+	realPath, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return err
+	}
+	visited[realPath] = true
+	defer delete(visited, realPath)
+
+	// Read the folder content
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(dirPath, entry.Name())
+		relativePath := joinVaultPath(relativeDir, entry.Name())
+		mode := entry.Type()
+
+		// Apply the symbolic link policy
+		if mode&fs.ModeSymlink != 0 {
+			if options.Symlinks != SymlinkFollow {
+				summary.add(TransferResult{Path: relativePath, Skipped: true})
+				continue
+			}
+
+			stat, err := os.Stat(entryPath)
+			if err != nil {
+				summary.add(TransferResult{Path: relativePath, Err: err})
+				continue
+			}
+			mode = stat.Mode().Type()
+		}
+
+		switch {
+		case mode.IsDir():
+			// Excluded folders are left out with their content
+			if options.excluded(relativePath) {
+				summary.add(TransferResult{Path: relativePath + folderSeparator, Skipped: true})
+				continue
+			}
+
+			subfolderPath := joinVaultPath(vaultFolder, entry.Name())
+			err = checkSymlinkLoop(entryPath, visited)
+			if err == nil {
+				err = CreateFolder(v, subfolderPath)
+			}
+			if err == nil {
+				err = importDir(v, key, entryPath, subfolderPath, relativePath, options, summary, visited)
+			}
+			if err != nil {
+				summary.add(TransferResult{Path: relativePath + folderSeparator, Err: err})
+			}
+
+		case mode.IsRegular():
+			if options.excluded(relativePath) || !options.included(relativePath) {
+				summary.add(TransferResult{Path: relativePath, Skipped: true})
+				continue
+			}
+
+			err = AddFileToVault(v, key, entryPath, vaultFolder, false, options.SkipAttributes)
+			if err != nil {
+				summary.add(TransferResult{Path: relativePath, Err: err})
+				continue
+			}
+			summary.add(TransferResult{Path: relativePath, Size: v.FilesMetadata[len(v.FilesMetadata)-1].Size})
+
+		default:
+			// Special files can't be imported
+			summary.add(TransferResult{Path: relativePath, Skipped: true})
+		}
+	}
+
+	return nil
+}
+
+// Checks the folder isn't one of the folders being imported
+func checkSymlinkLoop(dirPath string, visited map[string]bool) error {
+	realPath, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return err
+	}
+	if visited[realPath] {
+		return fmt.Errorf("%w: %s", ErrSymlinkLoop, dirPath)
+	}
+	return nil
+}
+
+// Checks the patterns are well formed
+func (o TransferOptions) validate() error {
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("%w: invalid pattern: %q", ErrInvalidArgument, pattern)
+		}
+	}
+	if o.Symlinks != SymlinkSkip && o.Symlinks != SymlinkFollow {
+		return fmt.Errorf("%w: unknown symbolic link policy: %d", ErrInvalidArgument, o.Symlinks)
+	}
+	return nil
+}
+
+// Reports whether the path matches an include pattern
+func (o TransferOptions) included(relativePath string) bool {
+	if len(o.Include) == 0 {
+		return true
+	}
+	return matchAny(o.Include, relativePath)
+}
+
+// Reports whether the path or one of its parent folders matches an exclude pattern
+func (o TransferOptions) excluded(relativePath string) bool {
+	for ; relativePath != ""; relativePath = parentFolder(relativePath) {
+		if matchAny(o.Exclude, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, relativePath string) bool {
+	for _, pattern := range patterns {
+		name := relativePath
+		if !strings.Contains(pattern, folderSeparator) {
+			name = path.Base(relativePath)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path relative to the folder containing it
+func relativeVaultPath(vaultPath string, folderPath string) string {
+	if folderPath == "" {
+		return vaultPath
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(vaultPath, folderPath), folderSeparator)
+}
@@ -4,51 +4,131 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// CipherSuite identifies the AEAD cipher used to encrypt vault blobs
+type CipherSuite uint8
+
 const (
-	CipherBlockSize = aes.BlockSize
+	CipherAES256GCM CipherSuite = iota + 1
+	CipherXChaCha20Poly1305
 )
 
-func Encrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+const (
+	DefaultCipherSuite = CipherAES256GCM
+)
+
+var ErrDecryptionFailed = errors.New("decryption failed: wrong key or corrupted data")
+
+// CipherSuites lists the supported cipher suites
+var CipherSuites = []CipherSuite{
+	CipherAES256GCM,
+	CipherXChaCha20Poly1305,
+}
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "AES-256-GCM"
+	case CipherXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown cipher (%d)", uint8(c))
+	}
+}
+
+// ParseCipherSuite returns the cipher suite with the given name
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	for _, suite := range CipherSuites {
+		if suite.String() == name {
+			return suite, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite: %s", name)
+}
+
+// Overhead returns the number of bytes Encrypt adds to the plaintext
+func Overhead(suite CipherSuite) (int, error) {
+	aead, err := newAEAD(suite, make([]byte, chacha20poly1305.KeySize))
+	if err != nil {
+		return 0, err
+	}
+	return aead.NonceSize() + aead.Overhead(), nil
+}
+
+func Encrypt(data []byte, key []byte, suite CipherSuite) ([]byte, error) {
+	aead, err := newAEAD(suite, key)
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize a nonce (IV) for CTR mode (16 bytes for AES)
-	nonce := make([]byte, aes.BlockSize)
+	// Generate a random nonce
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
-	// Create the CTR stream cipher
-	stream := cipher.NewCTR(block, nonce)
+	// Encrypt and authenticate the data, appending it to the nonce
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
 
-	// Encrypt the data
-	ciphertext := make([]byte, len(data))
-	stream.XORKeyStream(ciphertext, data)
+func Decrypt(ciphertext []byte, key []byte, suite CipherSuite) ([]byte, error) {
+	aead, err := newAEAD(suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the ciphertext is long enough to hold the nonce and the tag
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrDecryptionFailed
+	}
+
+	// Extract the nonce from the beginning of the ciphertext
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	// Verify and decrypt the data
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
 
-	// Prepend the nonce to the ciphertext for use during decryption
-	return append(nonce, ciphertext...), nil
+	return plaintext, nil
 }
 
-func Decrypt(ciphertext []byte, key []byte) ([]byte, error) {
+// NewLegacyDecryptReader decrypts content of the original vault format, an IV followed by
+// AES-256-CTR ciphertext. Nothing is authenticated, a wrong key yields garbage.
+func NewLegacyDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	// Extract the nonce from the beginning of the ciphertext
-	nonce, ciphertext := ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
-
-	// Create the CTR stream cipher
-	stream := cipher.NewCTR(block, nonce)
+	// The IV precedes the ciphertext
+	iv := make([]byte, aes.BlockSize)
+	_, err = io.ReadFull(r, iv)
+	if err != nil {
+		return nil, err
+	}
 
-	// Decrypt the data
-	plaintext := make([]byte, len(ciphertext))
-	stream.XORKeyStream(plaintext, ciphertext)
+	return cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}, nil
+}
 
-	return plaintext, nil
+func newAEAD(suite CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher suite: %d", uint8(suite))
+	}
 }
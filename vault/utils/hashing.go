@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
+	"hash"
 	"io"
 	"os"
 )
@@ -15,16 +17,34 @@ func GenerateDataHash(data []byte) []byte {
 	return hash[:]
 }
 
+// Returns the HMAC-SHA256 of the data under the given key
+func GenerateDataMAC(data []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Returns a hash computing the HMAC-SHA256 of the data written into it
+func NewDataMAC(key []byte) hash.Hash {
+	return hmac.New(sha256.New, key)
+}
+
 func GenerateFileHash(file *os.File, startPosOffset, endPosOffset int64, startWhence, endWhence int) ([]byte, error) {
+	return generateFileDigest(file, sha256.New(), startPosOffset, endPosOffset, startWhence, endWhence)
+}
+
+// Returns the HMAC-SHA256 of the file section under the given key
+func GenerateFileMAC(file *os.File, key []byte, startPosOffset, endPosOffset int64, startWhence, endWhence int) ([]byte, error) {
+	return generateFileDigest(file, hmac.New(sha256.New, key), startPosOffset, endPosOffset, startWhence, endWhence)
+}
+
+func generateFileDigest(file *os.File, hasher hash.Hash, startPosOffset, endPosOffset int64, startWhence, endWhence int) ([]byte, error) {
 	// Save the current file pointer position
 	currentPos, err := file.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize the hasher
-	hasher := sha256.New()
-
 	limitedReader, err := GetLimitedReader(file, startPosOffset, endPosOffset, startWhence, endWhence)
 	if err != nil {
 		return nil, err
@@ -2,18 +2,72 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 )
 
 const (
-	saltLength  = 16        // Salt length in bytes
-	timeCost    = 3         // Number of iterations
-	memoryCost  = 64 * 1024 // Memory in KiB (64 MiB)
-	parallelism = 2         // Number of threads
-	keyLength   = 32        // Key length in bytes
+	saltLength    = 16 // Salt length in bytes
+	keyLength     = 32 // Key length in bytes
+	keyfileLength = 64 // Generated keyfile length in bytes
 )
 
+const (
+	maxKDFTime   = 1000            // Maximum number of iterations
+	maxKDFMemory = 4 * 1024 * 1024 // Maximum memory in KiB (4 GiB)
+)
+
+// KDFAlgorithm identifies the function used to derive the vault key from the password
+type KDFAlgorithm uint8
+
+const (
+	KDFArgon2id KDFAlgorithm = iota + 1
+)
+
+// KDFParams holds the Argon2id cost parameters of a vault
+type KDFParams struct {
+	Time    uint32 // Number of iterations
+	Memory  uint32 // Memory in KiB
+	Threads uint8  // Number of threads
+}
+
+// KDFPreset is a named set of KDF parameters
+type KDFPreset struct {
+	Name   string
+	Params KDFParams
+}
+
+// KDFPresets lists the predefined KDF parameters, from fastest to strongest
+var KDFPresets = []KDFPreset{
+	{Name: "Interactive", Params: KDFParams{Time: 2, Memory: 64 * 1024, Threads: 2}},
+	{Name: "Moderate", Params: KDFParams{Time: 3, Memory: 256 * 1024, Threads: 2}},
+	{Name: "Paranoid", Params: KDFParams{Time: 4, Memory: 1024 * 1024, Threads: 4}},
+}
+
+// LegacyKDFParams are used by vaults that were created before the parameters were stored
+var LegacyKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 2}
+
+const (
+	VaultMACContext = "secure_vault vault mac" // HKDF context for the vault integrity MAC key
+	KeyWrapContext  = "secure_vault key wrap"  // HKDF context for the key wrapping the master key
+	FileMACContext  = "secure_vault file mac"  // HKDF context for the file integrity hash key
+)
+
+func (k KDFAlgorithm) String() string {
+	switch k {
+	case KDFArgon2id:
+		return "Argon2id"
+	default:
+		return fmt.Sprintf("unknown KDF (%d)", uint8(k))
+	}
+}
+
 // GenerateSalt creates a random salt for Argon2.
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, saltLength)
@@ -24,7 +78,107 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
-// DeriveKey derives a key using Argon2id.
-func DeriveKey(password string, salt []byte) []byte {
-	return argon2.IDKey([]byte(password), salt, timeCost, memoryCost, parallelism, keyLength)
+// FindKDFPreset returns the parameters of the preset with the given name.
+func FindKDFPreset(name string) (KDFParams, error) {
+	for _, preset := range KDFPresets {
+		if preset.Name == name {
+			return preset.Params, nil
+		}
+	}
+	return KDFParams{}, fmt.Errorf("unknown KDF preset: %s", name)
+}
+
+// Validate checks that the parameters are usable and within sane bounds.
+func (p KDFParams) Validate() error {
+	if p.Time < 1 || p.Time > maxKDFTime {
+		return fmt.Errorf("KDF iterations must be between 1 and %d", maxKDFTime)
+	}
+	if p.Threads < 1 {
+		return fmt.Errorf("KDF threads must be at least 1")
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory {
+		return fmt.Errorf("KDF memory must be between %d and %d KiB", 8*uint32(p.Threads), maxKDFMemory)
+	}
+	return nil
+}
+
+// CalibrateKDF picks the number of iterations that makes a key derivation with
+// the given memory and threads take about the target duration on this machine.
+func CalibrateKDF(target time.Duration, memory uint32, threads uint8) (KDFParams, error) {
+	params := KDFParams{Time: 1, Memory: memory, Threads: threads}
+	err := params.Validate()
+	if err != nil {
+		return KDFParams{}, err
+	}
+
+	// Measure a single iteration
+	salt := make([]byte, saltLength)
+	start := time.Now()
+	argon2.IDKey([]byte("calibration"), salt, params.Time, params.Memory, params.Threads, keyLength)
+	elapsed := time.Since(start)
+
+	// Scale the iterations linearly to reach the target
+	if elapsed > 0 && elapsed < target {
+		params.Time = uint32(min(int64(target/elapsed), maxKDFTime))
+	}
+
+	return params, nil
+}
+
+// GenerateKey creates a random key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, keyLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GenerateKeyfile writes a new keyfile filled with random bytes.
+func GenerateKeyfile(keyfilePath string) error {
+	keyfileData := make([]byte, keyfileLength)
+	_, err := rand.Read(keyfileData)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keyfilePath, keyfileData, 0600)
+}
+
+// HashKeyfile returns the SHA-256 hash of the keyfile content.
+func HashKeyfile(keyfilePath string) ([]byte, error) {
+	keyfile, err := os.Open(keyfilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer keyfile.Close()
+
+	// Stream the keyfile content into the hasher in chunks
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// WipeKey overwrites the key with zeros, so it doesn't stay in memory.
+func WipeKey(key []byte) {
+	clear(key)
+}
+
+// DeriveKey derives a key from the secret using Argon2id.
+func DeriveKey(secret []byte, salt []byte, params KDFParams) []byte {
+	return argon2.IDKey(secret, salt, params.Time, params.Memory, params.Threads, keyLength)
+}
+
+// DeriveSubkey derives an independent subkey from the key using HKDF-SHA256.
+func DeriveSubkey(key []byte, context string) ([]byte, error) {
+	subkey := make([]byte, keyLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(context)), subkey)
+	if err != nil {
+		return nil, err
+	}
+	return subkey, nil
 }
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+	Encrypted stream has the following structure:
+	Stream Salt		[16]byte (random, derives the stream key from the key)
+	Chunks			[]Chunk (each chunk is sealed independently)
+
+	Every chunk holds chunkSize bytes of plaintext, except the last one which
+	may hold less (or none). The nonce of a chunk is its counter followed by a
+	flag marking the last chunk, so chunks can't be reordered, dropped or
+	appended without the authentication failing (STREAM construction).
+*/
+
+const (
+	DefaultChunkSize = 64 * 1024 // Plaintext size of a chunk in bytes
+	streamSaltLength = 16        // Stream salt length in bytes
+)
+
+const (
+	StreamContext = "secure_vault stream" // HKDF context for the stream key
+)
+
+// EncryptStream encrypts everything read from r into chunks written to w,
+// and returns the number of plaintext bytes read.
+func EncryptStream(w io.Writer, r io.Reader, key []byte, suite CipherSuite, chunkSize int) (int64, error) {
+	// Generate a random stream salt
+	salt := make([]byte, streamSaltLength)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write the stream salt
+	_, err = w.Write(salt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Derive the stream key
+	aead, err := newStreamAEAD(key, salt, suite)
+	if err != nil {
+		return 0, err
+	}
+
+	plaintext := make([]byte, chunkSize)
+	ciphertext := make([]byte, 0, chunkSize+aead.Overhead())
+	nonce := make([]byte, aead.NonceSize())
+	bufferedReader := bufio.NewReaderSize(r, chunkSize)
+	var size int64
+
+	for counter := uint64(0); ; counter++ {
+		// Read the next chunk
+		n, err := io.ReadFull(bufferedReader, plaintext)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		size += int64(n)
+
+		// The chunk is the last one if nothing follows it
+		last := n < chunkSize
+		if !last {
+			_, err = bufferedReader.Peek(1)
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			last = err == io.EOF
+		}
+
+		// Seal and write the chunk
+		setStreamNonce(nonce, counter, last)
+		ciphertext = aead.Seal(ciphertext[:0], nonce, plaintext[:n], nil)
+		_, err = w.Write(ciphertext)
+		if err != nil {
+			return 0, err
+		}
+
+		if last {
+			return size, nil
+		}
+	}
+}
+
+// DecryptStream decrypts the chunks read from r into w, and returns the
+// number of plaintext bytes written. Every chunk is authenticated before it
+// is written, r must end right after the last chunk.
+func DecryptStream(w io.Writer, r io.Reader, key []byte, suite CipherSuite, chunkSize int) (int64, error) {
+	// Read the stream salt
+	salt := make([]byte, streamSaltLength)
+	_, err := io.ReadFull(r, salt)
+	if err != nil {
+		return 0, ErrDecryptionFailed
+	}
+
+	// Derive the stream key
+	aead, err := newStreamAEAD(key, salt, suite)
+	if err != nil {
+		return 0, err
+	}
+
+	ciphertext := make([]byte, chunkSize+aead.Overhead())
+	plaintext := make([]byte, 0, chunkSize)
+	nonce := make([]byte, aead.NonceSize())
+	bufferedReader := bufio.NewReaderSize(r, chunkSize+aead.Overhead())
+	var size int64
+
+	for counter := uint64(0); ; counter++ {
+		// Read the next chunk
+		n, err := io.ReadFull(bufferedReader, ciphertext)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		// The chunk is the last one if nothing follows it
+		last := n < len(ciphertext)
+		if !last {
+			_, err = bufferedReader.Peek(1)
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			last = err == io.EOF
+		}
+
+		// Open and write the chunk
+		setStreamNonce(nonce, counter, last)
+		plaintext, err = aead.Open(plaintext[:0], nonce, ciphertext[:n], nil)
+		if err != nil {
+			return 0, ErrDecryptionFailed
+		}
+		_, err = w.Write(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		size += int64(len(plaintext))
+
+		if last {
+			return size, nil
+		}
+	}
+}
+
+func newStreamAEAD(key, salt []byte, suite CipherSuite) (cipher.AEAD, error) {
+	streamKey := make([]byte, keyLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(StreamContext)), streamKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(suite, streamKey)
+}
+
+// Writes the chunk counter and the last chunk flag into the nonce
+func setStreamNonce(nonce []byte, counter uint64, last bool) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const testChunkSize = 64
+
+func encryptTestStream(t *testing.T, plaintext []byte, key []byte, suite CipherSuite) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	size, err := EncryptStream(&buf, bytes.NewReader(plaintext), key, suite, testChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(plaintext)) {
+		t.Fatalf("encrypted %d bytes, want %d", size, len(plaintext))
+	}
+	return buf.Bytes()
+}
+
+func testPlaintext(size int) []byte {
+	plaintext := make([]byte, size)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	return plaintext
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sizes around the chunk boundaries
+	sizes := []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 2 * testChunkSize, 3*testChunkSize + 5}
+	for _, suite := range CipherSuites {
+		for _, size := range sizes {
+			plaintext := testPlaintext(size)
+			ciphertext := encryptTestStream(t, plaintext, key, suite)
+
+			var buf bytes.Buffer
+			n, err := DecryptStream(&buf, bytes.NewReader(ciphertext), key, suite, testChunkSize)
+			if err != nil {
+				t.Fatalf("%v, %d bytes: %v", suite, size, err)
+			}
+			if n != int64(size) || !bytes.Equal(buf.Bytes(), plaintext) {
+				t.Errorf("%v, %d bytes: decrypted content does not match", suite, size)
+			}
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suite := range CipherSuites {
+		aead, err := newAEAD(suite, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealedChunkSize := testChunkSize + aead.Overhead()
+
+		// Three full chunks, the last one flagged as last
+		ciphertext := encryptTestStream(t, testPlaintext(3*testChunkSize), key, suite)
+		chunk := func(i int) []byte {
+			start := streamSaltLength + i*sealedChunkSize
+			return ciphertext[start : start+sealedChunkSize]
+		}
+		concat := func(parts ...[]byte) []byte {
+			return bytes.Join(parts, nil)
+		}
+		salt := ciphertext[:streamSaltLength]
+
+		cases := []struct {
+			name       string
+			ciphertext []byte
+			key        []byte
+		}{
+			{"reordered chunks", concat(salt, chunk(1), chunk(0), chunk(2)), key},
+			{"dropped middle chunk", concat(salt, chunk(0), chunk(2)), key},
+			{"dropped last chunk", concat(salt, chunk(0), chunk(1)), key},
+			{"appended chunk", concat(salt, chunk(0), chunk(1), chunk(2), chunk(2)), key},
+			{"truncated inside a chunk", ciphertext[:len(ciphertext)-10], key},
+			{"truncated salt", ciphertext[:streamSaltLength-1], key},
+			{"flipped byte", concat(salt, chunk(0), flipByte(chunk(1)), chunk(2)), key},
+			{"wrong key", ciphertext, otherKey},
+		}
+		for _, c := range cases {
+			var buf bytes.Buffer
+			_, err := DecryptStream(&buf, bytes.NewReader(c.ciphertext), c.key, suite, testChunkSize)
+			if !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("%v, %s: got %v, want %v", suite, c.name, err, ErrDecryptionFailed)
+			}
+		}
+	}
+}
+
+func flipByte(data []byte) []byte {
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+	return flipped
+}
@@ -2,132 +2,720 @@ package vault
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"secure_vault/vault/utils"
 	"time"
 )
 
-/*
-	Vault file has the following structure in disk:
-	Vault Metadata Size 	int64 (LittleEndian)
-	Vault Metadata 			VaultMetadata
-	Files Metadata Size		int64 (LittleEndian)			[Encrypted]
-	Files Metadata			[]FileMetadata					[Encrypted]
-	Files					[]byte (dumped back to back)	[Encrypted]
-	Vault Integriy Hash		SHA256
-*/
+// The vault file structure in disk is described in vault_io.go
+
+const (
+	FormatVersion0       uint16 = 0              // Original format version, without a header
+	FormatVersion1       uint16 = 1              // Single password format version
+	FormatVersion2       uint16 = 2              // Key slots format version
+	FormatVersion3       uint16 = 3              // Append-only segments format version
+	CurrentFormatVersion        = FormatVersion3 // Format version used when saving
+)
+
+// Magic bytes at the start of every vault file
+var vaultMagic = [8]byte{'S', 'E', 'C', 'V', 'A', 'U', 'L', 'T'}
 
 type Vault struct {
+	Header        VaultHeader    // Header of the vault
 	Metadata      VaultMetadata  // Metadata of the vault
 	FilesMetadata []FileMetadata // Metadata for files
-	Files         []byte         // File content
+	Folders       []string       // Paths of the folders, sorted
+	Tombstones    []Tombstone    // Regions of the vault file that are no longer used
+	Backups       BackupSettings // Previous generations kept on save
+
+	vaultFile   *os.File    // Open vault file the saved files are read from, nil for a new vault
+	vaultPath   string      // Path of the open vault file
+	headerBytes []byte      // Header and metadata as they are in the vault file
+	stagingFile *os.File    // Temporary file holding the files added since the last save
+	saved       *savedState // State of the vault when it was loaded or last saved, nil if never saved
+	lockFile    *os.File    // Lock file held while the vault file is open for writing
+	readOnly    bool        // Vault was loaded without the write lock, changes fail with ErrReadOnly
+
+	// Key slots were revoked or their credentials changed, the next save
+	// deletes the generations that still unlock with them
+	purgeBackups bool
+}
+
+type VaultHeader struct {
+	Magic   [8]byte            // Identifies the file as a vault
+	Version uint16             // Format version the vault was written with
+	Cipher  utils.CipherSuite  // AEAD cipher for files and files metadata
+	KDF     utils.KDFAlgorithm // Key derivation function for the password
 }
 
 type VaultMetadata struct {
-	Salt      []byte    // Salt for key derivation
+	KeySlots  []KeySlot // Key slots that can unlock the master key
 	CreatedAt time.Time // Creation timestamp
 }
 
+type KeySlot struct {
+	Label      string          // Unique name of the key slot
+	Factors    Factor          // Secrets required to unlock the key slot
+	Salt       []byte          // Salt for key derivation
+	KDFParams  utils.KDFParams // Parameters for key derivation
+	WrappedKey []byte          // Master key encrypted with the password derived key
+	CreatedAt  time.Time       // Timestamp when the key slot was added
+}
+
 type FileMetadata struct {
-	Name          string    // Filename
-	Index         int64     // Index in the vault
-	Offset        int64     // Offset in the vault
-	IntegrityHash []byte    // Integrity hash is computed after the encryption
-	AddedAt       time.Time // Timestamp when the file was added
+	ID            string         // Random identifier given when the file is added, never changes
+	Name          string         // Filename
+	Folder        string         // Path of the folder containing the file, empty for the root
+	Offset        int64          // Offset in the vault file, or in the staging file if not saved yet
+	EncryptedSize int64          // Size of the encrypted content in the vault
+	Size          int64          // Size of the plaintext content
+	ChunkSize     int32          // Plaintext size of the encrypted chunks, 0 for a single encrypted blob
+	IntegrityHash []byte         // Keyed integrity hash (HMAC) is computed after the encryption
+	FileMACKey    bool           // Integrity hash is keyed with the file MAC subkey, not the master key
+	AddedAt       time.Time      // Timestamp when the file was added
+	Attributes    FileAttributes // Attributes of the original file
+
+	staged bool // Content is in the staging file, not saved yet
+}
+
+type Tombstone struct {
+	Offset int64 // Offset in the vault file
+	Size   int64 // Size of the unused region
 }
 
-func CreateVault(password string) (*Vault, error) {
-	// Generate a random salt
-	salt, err := utils.GenerateSalt()
+func CreateVault(credentials Credentials, cipherSuite utils.CipherSuite, kdfParams utils.KDFParams) (*Vault, []byte, error) {
+	// Check the key derivation parameters
+	err := kdfParams.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Generate a random master key
+	key, err := utils.GenerateKey()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Create an empty vault
 	v := &Vault{
+		Header: VaultHeader{
+			Magic:   vaultMagic,
+			Version: CurrentFormatVersion,
+			Cipher:  cipherSuite,
+			KDF:     utils.KDFArgon2id,
+		},
 		Metadata: VaultMetadata{
-			Salt:      salt,
+			KeySlots:  []KeySlot{},
 			CreatedAt: time.Now().Truncate(0),
 		},
 		FilesMetadata: []FileMetadata{},
-		Files:         []byte{},
+		Backups: BackupSettings{
+			Count: DefaultBackupCount,
+		},
+	}
+
+	// Add the first key slot for the credentials
+	err = AddKeySlot(v, key, DefaultKeySlotLabel, credentials, kdfParams)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return v, nil
+	return v, key, nil
 }
 
 func SaveVault(v *Vault, key []byte, vaultPath string) error {
-	// Create the vault file
-	vaultFile, err := os.Create(vaultPath)
+	// Without the write lock another process may be saving the vault
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// Vaults are always saved in the current format
+	v.Header.Magic = vaultMagic
+	v.Header.Version = CurrentFormatVersion
+
+	// Encode the header and the metadata
+	headerBytes, err := encodeVaultHeader(&v.Header, &v.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// Keep the saved vault file as a generation before changing it, unless
+	// the generations are purged
+	sameVault := v.vaultFile != nil && sameFile(v.vaultPath, vaultPath)
+	appending := sameVault && bytes.Equal(headerBytes, v.headerBytes)
+	if sameVault && !v.purgeBackups {
+		err = backupVault(v, appending)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case appending:
+		// Append the changes if the vault file is in the current format and its header is unchanged
+		err = appendVault(v, key)
+	case sameVault:
+		// Otherwise rewrite the whole vault
+		err = rewriteVault(v, key, vaultPath, headerBytes)
+	default:
+		err = saveVaultAs(v, key, vaultPath, headerBytes)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The generations still unlock with the revoked key slots and the old credentials
+	if v.purgeBackups {
+		err = PurgeBackups(v)
+		if err != nil {
+			return err
+		}
+		v.purgeBackups = false
+	}
+	return nil
+}
+
+// Writes the vault into a new vault file under its own lock, which replaces
+// the lock of the previous one
+func saveVaultAs(v *Vault, key []byte, vaultPath string, headerBytes []byte) error {
+	lockFile, err := lockVault(vaultPath)
+	if err != nil {
+		return err
+	}
+	err = rewriteVault(v, key, vaultPath, headerBytes)
+	if err != nil {
+		unlockVault(lockFile)
+		return err
+	}
+	unlockVault(v.lockFile)
+	v.lockFile = lockFile
+	return nil
+}
+
+// CompactVault rewrites the vault file without the unused regions
+func CompactVault(v *Vault, key []byte) error {
+	if v.vaultFile == nil {
+		return ErrNotSaved
+	}
+	if v.readOnly {
+		return ErrReadOnly
+	}
+
+	// Vaults are always saved in the current format
+	v.Header.Magic = vaultMagic
+	v.Header.Version = CurrentFormatVersion
+
+	// Encode the header and the metadata
+	headerBytes, err := encodeVaultHeader(&v.Header, &v.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return rewriteVault(v, key, v.vaultPath, headerBytes)
+}
+
+// DeadSpace returns the number of bytes in the vault file that are no longer used
+func DeadSpace(v *Vault) int64 {
+	var deadSpace int64
+	for _, tombstone := range v.Tombstones {
+		deadSpace += tombstone.Size
+	}
+	return deadSpace
+}
+
+func appendVault(v *Vault, key []byte) error {
+	// Get the end of the last segment
+	stat, err := v.vaultFile.Stat()
+	if err != nil {
+		return err
+	}
+	segmentOffset := stat.Size()
+
+	// The previous files metadata and trailer are superseded by the new segment
+	trailer, _, err := readVaultTrailer(v.vaultFile, int64(len(v.headerBytes)))
+	if err != nil {
+		return err
+	}
+	tombstones := append(v.Tombstones, Tombstone{
+		Offset: trailer.FilesMetadataOffset,
+		Size:   segmentOffset - trailer.FilesMetadataOffset,
+	})
+
+	// Record the end of the last segment to roll back to if the append is interrupted
+	err = writeJournal(v.vaultPath, segmentOffset)
+	if err != nil {
+		return err
+	}
+
+	// Append the new segment
+	filesMetadata := make([]FileMetadata, len(v.FilesMetadata))
+	copy(filesMetadata, v.FilesMetadata)
+	err = appendSegment(v, key, segmentOffset, filesMetadata, tombstones)
+
+	// Make sure the vault file reads back before dropping the journal
+	if err == nil {
+		err = verifyVaultFile(v.vaultPath, key)
+	}
+	if err != nil {
+		rollbackJournal(v.vaultPath)
+		return err
+	}
+	err = removeJournal(v.vaultPath)
+	if err != nil {
+		return err
+	}
+
+	// The staged files are in the vault file from now on
+	v.FilesMetadata = filesMetadata
+	v.Tombstones = tombstones
+	closeStagingFile(v)
+
+	return markSaved(v)
+}
+
+func appendSegment(v *Vault, key []byte, segmentOffset int64, filesMetadata []FileMetadata, tombstones []Tombstone) error {
+	// Open the vault file for appending
+	vaultFile, err := os.OpenFile(v.vaultPath, os.O_WRONLY, 0)
 	if err != nil {
 		return err
 	}
 	defer vaultFile.Close()
 
-	// Save the metadata
-	err = writeVaultMetadata(vaultFile, &v.Metadata)
+	_, err = vaultFile.Seek(segmentOffset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Append the staged files
+	err = writeFiles(vaultFile, v, filesMetadata, true)
+	if err != nil {
+		return err
+	}
+
+	// Append the files metadata and the trailer
+	err = writeSegmentEnd(vaultFile, v, key, v.headerBytes, filesMetadata, tombstones)
+	if err != nil {
+		return err
+	}
+
+	// Flush the segment to the disk
+	return vaultFile.Sync()
+}
+
+func rewriteVault(v *Vault, key []byte, vaultPath string, headerBytes []byte) error {
+	// Write into a temporary file next to the vault, the saved files are read from the current one
+	tempFile, err := os.CreateTemp(filepath.Dir(vaultPath), filepath.Base(tempPattern(vaultPath)))
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+
+	// Write the header and the metadata
+	_, err = tempFile.Write(headerBytes)
+
+	// Write the files back to back, followed by the files metadata and the trailer
+	filesMetadata := make([]FileMetadata, len(v.FilesMetadata))
+	copy(filesMetadata, v.FilesMetadata)
+	if err == nil {
+		err = writeFiles(tempFile, v, filesMetadata, false)
+	}
+	if err == nil {
+		err = writeSegmentEnd(tempFile, v, key, headerBytes, filesMetadata, nil)
+	}
+
+	// Flush the temporary file to the disk
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	// Make sure the temporary file reads back before replacing the vault
+	if err == nil {
+		err = verifyVaultFile(tempPath, key)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// The generations kept by reference point into the replaced vault file, unless they are purged
+	if v.vaultFile != nil && sameFile(v.vaultPath, vaultPath) && !v.purgeBackups {
+		err = copyBackupReferences(v)
+		if err != nil {
+			os.Remove(tempPath)
+			return err
+		}
+	}
+
+	// Replace the vault with the temporary file
+	err = replaceVaultFile(v, vaultPath, func() error {
+		return os.Rename(tempPath, vaultPath)
+	})
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Make the rename durable
+	err = syncDir(filepath.Dir(vaultPath))
+	if err != nil {
+		return err
+	}
+
+	// Read the files from the saved vault from now on
+	vaultFile, err := os.Open(vaultPath)
 	if err != nil {
 		return err
 	}
+	closeVaultFile(v)
+	v.vaultFile = vaultFile
+	v.vaultPath = vaultPath
+	v.headerBytes = headerBytes
+	v.FilesMetadata = filesMetadata
+	v.Tombstones = nil
+
+	return markSaved(v)
+}
+
+// Replaces the file at the path. Windows can't replace a file that is open,
+// so the vault file is closed first when it is the one replaced, and opened
+// again if the replacement fails.
+func replaceVaultFile(v *Vault, vaultPath string, replace func() error) error {
+	if v.vaultFile == nil || !sameFile(v.vaultPath, vaultPath) {
+		return replace()
+	}
 
-	// Save the files metadata
-	err = writeFilesMetadata(vaultFile, key, v.FilesMetadata)
+	v.vaultFile.Close()
+	v.vaultFile = nil
+	err := replace()
 	if err != nil {
+		vaultFile, openErr := os.Open(v.vaultPath)
+		if openErr == nil {
+			v.vaultFile = vaultFile
+		}
 		return err
 	}
+	return nil
+}
+
+func writeSegmentEnd(vaultFile *os.File, v *Vault, key []byte, headerBytes []byte, filesMetadata []FileMetadata, tombstones []Tombstone) error {
+	// Write the files metadata
+	index := &filesIndex{
+		Files:      filesMetadata,
+		Tombstones: tombstones,
+		Folders:    v.Folders,
+		Backups:    v.Backups,
+	}
+	filesMetadataOffset, encryptedFilesMetadata, err := writeFilesMetadata(vaultFile, key, v.Header.Cipher, index)
+	if err != nil {
+		return err
+	}
+
+	// Write the trailer
+	return writeVaultTrailer(vaultFile, key, headerBytes, filesMetadataOffset, encryptedFilesMetadata)
+}
+
+// LoadOptions change how a vault is loaded
+type LoadOptions struct {
+	ReadOnly bool // Load without the write lock, every change fails with ErrReadOnly
+}
+
+// LoadVault opens the vault for writing, holding its write lock until it is closed.
+// Fails with a VaultInUseError if another process has the vault open for writing.
+// A read-only vault doesn't take the lock, so it loads while another process writes
+// the vault, and sees its last completed save.
+func LoadVault(credentials Credentials, vaultPath string, options LoadOptions) (*Vault, []byte, error) {
+	if options.ReadOnly {
+		return loadVaultReadOnly(credentials, vaultPath)
+	}
+
+	// The lock file stays behind, so only vaults get one
+	err := checkVaultFile(vaultPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep other writers away while the vault is open
+	lockFile, err := lockVault(vaultPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Recover from an interrupted save
+	err = RecoverVault(vaultPath)
+	if err != nil {
+		unlockVault(lockFile)
+		return nil, nil, err
+	}
+
+	// Load the vault
+	v, key, err := openVault(credentials, vaultPath)
+	if err != nil {
+		unlockVault(lockFile)
+		return nil, nil, err
+	}
+
+	v.lockFile = lockFile
+	return v, key, nil
+}
 
-	// Save the files
-	err = writeFiles(vaultFile, v.Files)
+// Checks that the file exists and starts like a vault
+func checkVaultFile(vaultPath string) error {
+	vaultFile, err := os.Open(vaultPath)
 	if err != nil {
 		return err
 	}
+	defer vaultFile.Close()
 
-	// Save the vault hash
-	err = writeVaultHash(vaultFile)
+	_, err = readVaultHeader(vaultFile)
+	if errors.Is(err, ErrNotVault) {
+		// Vaults of the original format start with the metadata
+		_, err = readVaultMetadataV0(vaultFile)
+	}
 	return err
 }
 
-func LoadVault(password, vaultPath string) (*Vault, error) {
+func loadVaultReadOnly(credentials Credentials, vaultPath string) (*Vault, []byte, error) {
+	// An append in progress or interrupted can only be rolled back by a writer
+	_, err := os.Stat(vaultPath + journalSuffix)
+	if err == nil {
+		return nil, nil, fmt.Errorf("%w, try again or open it for writing: %s", ErrUnfinishedSave, vaultPath)
+	}
+
+	// Load the vault
+	v, key, err := openVault(credentials, vaultPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v.readOnly = true
+	return v, key, nil
+}
+
+// IsReadOnly reports whether the vault was loaded read-only
+func IsReadOnly(v *Vault) bool {
+	return v.readOnly
+}
+
+func openVault(credentials Credentials, vaultPath string) (*Vault, []byte, error) {
 	// Open the vault file
 	vaultFile, err := os.Open(vaultPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer vaultFile.Close()
 
-	// Load the metadata
-	metadata, err := readVaultMetadata(vaultFile)
+	// Load everything but the files
+	v, key, err := readVault(vaultFile, credentials)
 	if err != nil {
-		return nil, err
+		vaultFile.Close()
+		return nil, nil, err
 	}
 
-	// Derive the key using the password and salt
-	key := utils.DeriveKey(password, metadata.Salt)
+	// Keep the vault file open, the files are read on demand
+	v.vaultFile = vaultFile
+	v.vaultPath = vaultPath
+	return v, key, nil
+}
 
-	// Load the files metadata
-	filesMetadata, err := readFilesMetadata(vaultFile, key)
+func readVault(vaultFile *os.File, credentials Credentials) (*Vault, []byte, error) {
+	// Load the header
+	header, err := readVaultHeader(vaultFile)
+	if errors.Is(err, ErrNotVault) {
+		// Vaults of the original format start with the metadata
+		return readVaultV0(vaultFile, credentials)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Load the files
-	files, err := readFiles(vaultFile)
+	// Check the key derivation function
+	if header.KDF != utils.KDFArgon2id {
+		return nil, nil, fmt.Errorf("%w: unsupported key derivation function: %v", ErrCorruptHeader, header.KDF)
+	}
+
+	// Load the metadata according to the format version
+	var metadata *VaultMetadata
+	switch header.Version {
+	case FormatVersion1:
+		metadata, err = readVaultMetadataV1(vaultFile)
+	case FormatVersion2, FormatVersion3:
+		metadata, err = readVaultMetadata(vaultFile)
+	default:
+		return nil, nil, &UnsupportedVersionError{Version: header.Version}
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Key slots created before keyfile support only use a password
+	for i := range metadata.KeySlots {
+		if metadata.KeySlots[i].Factors == 0 {
+			metadata.KeySlots[i].Factors = FactorPassword
+		}
 	}
 
 	// Reconstruct the vault structure
 	v := &Vault{
-		Metadata:      *metadata,
-		FilesMetadata: filesMetadata,
-		Files:         files,
+		Header:   *header,
+		Metadata: *metadata,
+	}
+
+	// Unlock the master key with one of the key slots
+	legacy := hasLegacyKeySlot(v)
+	key, err := unlockMasterKey(v, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Vaults before the append-only segments store the files metadata before the
+	// files, and have a MAC over the whole file checked before trusting any of it
+	if header.Version < FormatVersion3 {
+		var integrity bool
+		integrity, err = checkVaultHashV2(vaultFile, key)
+		if err == nil && !integrity {
+			err = fmt.Errorf("%w: vault MAC does not match", ErrIntegrityMismatch)
+		}
+		if err == nil {
+			v.FilesMetadata, err = readFilesMetadataV2(vaultFile, key, header.Cipher)
+		}
+	} else {
+		err = readLatestFilesIndex(vaultFile, v, key)
+	}
+
+	// Keys of legacy key slots are only verified by the files metadata
+	if legacy && errors.Is(err, ErrIntegrityMismatch) {
+		utils.WipeKey(key)
+		return nil, nil, ErrWrongPassword
+	}
+
+	// Files saved before file IDs get one
+	if err == nil {
+		err = assignFileIDs(v)
+	}
+
+	// Changes are tracked from the loaded state
+	if err == nil {
+		err = markSaved(v)
+	}
+	if err != nil {
+		utils.WipeKey(key)
+		return nil, nil, err
+	}
+
+	return v, key, nil
+}
+
+func readLatestFilesIndex(vaultFile *os.File, v *Vault, key []byte) error {
+	// Keep the header and the metadata to detect changes on save
+	var err error
+	v.headerBytes, err = readHeaderBytes(vaultFile)
+	if err != nil {
+		return err
+	}
+
+	// Load the latest files metadata, the files are read on demand
+	trailer, _, err := readVaultTrailer(vaultFile, int64(len(v.headerBytes)))
+	if err != nil {
+		return err
+	}
+	encryptedFilesMetadata, err := readEncryptedFilesMetadata(vaultFile, trailer)
+	if err != nil {
+		return err
+	}
+
+	// Check the vault MAC before trusting anything read from the vault
+	vaultMAC, err := computeVaultMAC(key, v.headerBytes, encryptedFilesMetadata, trailer)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(vaultMAC, trailer.MAC[:]) {
+		return fmt.Errorf("%w: vault MAC does not match", ErrIntegrityMismatch)
+	}
+
+	index, err := readFilesMetadata(encryptedFilesMetadata, key, v.Header.Cipher)
+	if err != nil {
+		return err
+	}
+	v.FilesMetadata = index.Files
+	v.Tombstones = index.Tombstones
+	v.Folders = index.Folders
+	v.Backups = index.Backups
+
+	return nil
+}
+
+// CloseVault releases the vault file and its write lock, and drops the files added since the last save
+func CloseVault(v *Vault) error {
+	err := closeVaultFile(v)
+
+	// Let other writers open the vault
+	lockErr := unlockVault(v.lockFile)
+	v.lockFile = nil
+	if err == nil {
+		err = lockErr
+	}
+
+	return err
+}
+
+func closeVaultFile(v *Vault) error {
+	var err error
+
+	// Close the vault file
+	if v.vaultFile != nil {
+		err = v.vaultFile.Close()
+		v.vaultFile = nil
+	}
+
+	closeStagingFile(v)
+	return err
+}
+
+// LockVault closes the vault, then wipes the key and the decrypted files
+// metadata from memory. The vault must be loaded again to be used.
+func LockVault(v *Vault, key []byte) error {
+	err := CloseVault(v)
+
+	// Wipe the master key
+	utils.WipeKey(key)
+
+	// Wipe the decrypted metadata, strings like the names can only be dropped
+	for i := range v.FilesMetadata {
+		utils.WipeKey(v.FilesMetadata[i].IntegrityHash)
+		for _, value := range v.FilesMetadata[i].Attributes.Xattrs {
+			utils.WipeKey(value)
+		}
 	}
+	clear(v.FilesMetadata)
+	clear(v.Folders)
+	v.FilesMetadata = nil
+	v.Folders = nil
+	v.Tombstones = nil
+	v.headerBytes = nil
+	v.saved = nil
+
+	return err
+}
 
-	return v, nil
+func closeStagingFile(v *Vault) {
+	// Remove the staging file
+	if v.stagingFile != nil {
+		v.stagingFile.Close()
+		os.Remove(v.stagingFile.Name())
+		v.stagingFile = nil
+	}
 }
 
-func CheckVaultIntegrity(vaultPath string) (bool, error) {
+func CheckVaultIntegrity(vaultPath string, key []byte) (bool, error) {
 	// Open the vault file
 	vaultFile, err := os.Open(vaultPath)
 	if err != nil {
@@ -135,17 +723,40 @@ func CheckVaultIntegrity(vaultPath string) (bool, error) {
 	}
 	defer vaultFile.Close()
 
-	// Load the vault hash
-	expectedVaultHash, err := readVaultHash(vaultFile)
+	// Load the header
+	header, err := readVaultHeader(vaultFile)
+	if errors.Is(err, ErrNotVault) {
+		// Vaults of the original format have a hash over the whole file
+		return checkVaultHashV0(vaultFile)
+	}
 	if err != nil {
 		return false, err
 	}
 
-	// Verify the data integrity by recomputing the hash
-	vaultHash, err := utils.GenerateFileHash(vaultFile, 0, -utils.HashSize, io.SeekStart, io.SeekEnd)
+	// Vaults before the append-only segments have a MAC over the whole file
+	if header.Version < FormatVersion3 {
+		return checkVaultHashV2(vaultFile, key)
+	}
+
+	// Skip the metadata
+	_, err = readVaultMetadata(vaultFile)
 	if err != nil {
 		return false, err
 	}
 
-	return bytes.Equal(vaultHash, expectedVaultHash), nil
+	// Verify the MAC in the trailer
+	return checkVaultTrailer(vaultFile, key)
+}
+
+// Reports whether both paths point to the same file
+func sameFile(path1, path2 string) bool {
+	stat1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+	stat2, err := os.Stat(path2)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(stat1, stat2)
 }
@@ -1,25 +1,77 @@
 package vault
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"secure_vault/vault/utils"
-	"unsafe"
 )
 
 /*
 	Vault file has the following structure in disk:
-	Vault Metadata Size 	int64 (LittleEndian)
+	Vault Header			VaultHeader (LittleEndian)
+	Vault Metadata Size 	int32 (LittleEndian)
 	Vault Metadata 			VaultMetadata
-	Files Metadata Size		int64 (LittleEndian)			[Encrypted]
-	Files Metadata			[]FileMetadata					[Encrypted]
-	Files					[]byte (dumped back to back)	[Encrypted]
-	Vault Integriy Hash		SHA256
+	Segments				[]Segment (one is appended on every save)
+	Vault Trailer			vaultTrailer (LittleEndian)
+
+	Segment has the following structure in disk:
+	Files					[]byte (added files, back to back)	[Encrypted, chunked AEAD stream]
+	Files Metadata			filesIndex							[Encrypted, AEAD]
+	Vault Trailer			vaultTrailer (LittleEndian, superseded by the next segment)
+
+	Only the last trailer is used. It points to the latest files metadata, which
+	holds the absolute offsets of the files and the tombstones of the regions
+	that are no longer used. The vault MAC covers the header, the vault metadata,
+	the latest files metadata and the trailer, the files are covered by their
+	own integrity hashes inside the files metadata.
 */
 
-func writeVaultMetadata(vaultFile *os.File, metadata *VaultMetadata) error {
+// Encrypted content of the files metadata block
+type filesIndex struct {
+	Files      []FileMetadata // Metadata for the live files
+	Tombstones []Tombstone    // Regions of the vault file that are no longer used
+	Folders    []string       // Paths of the folders, sorted
+	Backups    BackupSettings // Previous generations kept on save
+}
+
+// Fixed size record at the end of the vault file
+type vaultTrailer struct {
+	FilesMetadataOffset int64                // Offset of the latest files metadata
+	FilesMetadataSize   int64                // Size of the latest encrypted files metadata
+	MAC                 [utils.HashSize]byte // Vault integrity MAC
+}
+
+var trailerSize = int64(binary.Size(vaultTrailer{}))
+
+func encodeVaultHeader(header *VaultHeader, metadata *VaultMetadata) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	// Write the header
+	err := writeVaultHeader(buf, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the metadata
+	err = writeVaultMetadata(buf, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeVaultHeader(w io.Writer, header *VaultHeader) error {
+	// Write the fixed size header
+	return binary.Write(w, binary.LittleEndian, header)
+}
+
+func writeVaultMetadata(w io.Writer, metadata *VaultMetadata) error {
 	// Serialize the vault metadata
 	metadataBytes, err := utils.EncodeDataToBytes(*metadata)
 	if err != nil {
@@ -27,208 +79,289 @@ func writeVaultMetadata(vaultFile *os.File, metadata *VaultMetadata) error {
 	}
 
 	// Write the size of the vault metadata
-	err = binary.Write(vaultFile, binary.LittleEndian, int32(len(metadataBytes)))
+	err = binary.Write(w, binary.LittleEndian, int32(len(metadataBytes)))
 	if err != nil {
 		return err
 	}
 
 	// Write the vault metadata
-	_, err = vaultFile.Write(metadataBytes)
+	_, err = w.Write(metadataBytes)
 	return err
 }
 
-func writeFilesMetadata(vaultFile *os.File, key []byte, filesMetadata []FileMetadata) error {
+func writeFiles(vaultFile *os.File, v *Vault, filesMetadata []FileMetadata, stagedOnly bool) error {
+	// Copy the encrypted files one by one from where they are stored
+	for i := range filesMetadata {
+		if stagedOnly && !filesMetadata[i].staged {
+			continue
+		}
+
+		fileReader, err := getFileReader(v, v.FilesMetadata[i])
+		if err != nil {
+			return err
+		}
+
+		// Record the new offset of the file
+		filesMetadata[i].Offset, err = vaultFile.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		filesMetadata[i].staged = false
+
+		_, err = io.Copy(vaultFile, fileReader)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFilesMetadata(vaultFile *os.File, key []byte, cipherSuite utils.CipherSuite, index *filesIndex) (int64, []byte, error) {
 	// Serialize the files metadata
-	filesMetadataBytes, err := utils.EncodeDataToBytes(filesMetadata)
+	filesMetadataBytes, err := utils.EncodeDataToBytes(*index)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	// Encrypt the files metadata
-	encryptedFilesMetadata, err := utils.Encrypt(filesMetadataBytes, key)
+	encryptedFilesMetadata, err := utils.Encrypt(filesMetadataBytes, key, cipherSuite)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	// Get the size of the file metadata
-	encryptedFilesMetadataSize := int32(len(encryptedFilesMetadata))
-
-	// Encode the size of the file metadata
-	encryptedFilesMetadataSizeBytes, err := utils.EncodeInt32ToBytes(encryptedFilesMetadataSize)
+	// Get the offset of the files metadata
+	offset, err := vaultFile.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	// Encrypt the size of the files metadata
-	encryptedFilesMetadataEncryptedSize, err := utils.Encrypt(encryptedFilesMetadataSizeBytes, key)
+	// Write the files metadata
+	_, err = vaultFile.Write(encryptedFilesMetadata)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
-	// Write the size of the files metadata
-	_, err = vaultFile.Write(encryptedFilesMetadataEncryptedSize)
+	return offset, encryptedFilesMetadata, nil
+}
+
+func writeVaultTrailer(vaultFile *os.File, key []byte, headerBytes []byte, filesMetadataOffset int64, encryptedFilesMetadata []byte) error {
+	trailer := vaultTrailer{
+		FilesMetadataOffset: filesMetadataOffset,
+		FilesMetadataSize:   int64(len(encryptedFilesMetadata)),
+	}
+
+	// Authenticate the header, the files metadata and the trailer
+	vaultMAC, err := computeVaultMAC(key, headerBytes, encryptedFilesMetadata, &trailer)
 	if err != nil {
 		return err
 	}
+	copy(trailer.MAC[:], vaultMAC)
 
-	// Write the files metadata
-	_, err = vaultFile.Write(encryptedFilesMetadata)
-	return err
+	// Write the trailer
+	return binary.Write(vaultFile, binary.LittleEndian, &trailer)
 }
 
-func writeFiles(vaultFile *os.File, files []byte) error {
-	// Write the files
-	_, err := vaultFile.Write(files)
-	return err
+func computeVaultMAC(key []byte, headerBytes []byte, encryptedFilesMetadata []byte, trailer *vaultTrailer) ([]byte, error) {
+	// Derive the vault MAC key
+	macKey, err := utils.DeriveSubkey(key, utils.VaultMACContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute the MAC over every authenticated part
+	mac := utils.NewDataMAC(macKey)
+	mac.Write(headerBytes)
+	mac.Write(encryptedFilesMetadata)
+	binary.Write(mac, binary.LittleEndian, trailer.FilesMetadataOffset)
+	binary.Write(mac, binary.LittleEndian, trailer.FilesMetadataSize)
+
+	return mac.Sum(nil), nil
 }
 
-func writeVaultHash(vaultFile *os.File) error {
-	// Generate the vault file hash
-	vaultHash, err := utils.GenerateFileHash(vaultFile, 0, 0, io.SeekStart, io.SeekEnd)
+func readVaultHeader(vaultFile *os.File) (*VaultHeader, error) {
+	// Read the fixed size header
+	var header VaultHeader
+	err := binary.Read(vaultFile, binary.LittleEndian, &header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrNotVault
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Write the vault file hash
-	_, err = vaultFile.Write(vaultHash)
-	return err
+	// Check the magic bytes
+	if header.Magic != vaultMagic {
+		return nil, ErrNotVault
+	}
+
+	return &header, nil
 }
 
 func readVaultMetadata(vaultFile *os.File) (*VaultMetadata, error) {
+	var metadata VaultMetadata
+	err := readVaultMetadataInto(vaultFile, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+func readVaultMetadataInto(vaultFile *os.File, metadata interface{}) error {
 	// Read the size of the metadata
 	var metadataSize int32
 	err := binary.Read(vaultFile, binary.LittleEndian, &metadataSize)
 	if err != nil {
-		return nil, err
+		return truncatedError(err)
 	}
 
 	// Check incorrect size
 	if metadataSize < 0 {
-		return nil, fmt.Errorf("error while reading vault metadata")
+		return fmt.Errorf("%w: invalid vault metadata size: %d", ErrCorruptHeader, metadataSize)
+	}
+
+	// Check the metadata fits in the file before allocating it
+	stat, err := vaultFile.Stat()
+	if err != nil {
+		return err
+	}
+	position, err := vaultFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if int64(metadataSize) > stat.Size()-position {
+		return fmt.Errorf("%w: vault metadata size %d exceeds the file", ErrTruncated, metadataSize)
 	}
 
 	// Read the unencrypted metadata
 	metadataBytes := make([]byte, metadataSize)
-	_, err = vaultFile.Read(metadataBytes)
+	_, err = io.ReadFull(vaultFile, metadataBytes)
 	if err != nil {
-		return nil, err
+		return truncatedError(err)
 	}
 
 	// Decode the metadata
-	var metadata VaultMetadata
-	err = utils.DecodeDataFromBytes(metadataBytes, &metadata)
+	err = utils.DecodeDataFromBytes(metadataBytes, metadata)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%w: %v", ErrCorruptHeader, err)
 	}
-
-	return &metadata, nil
+	return nil
 }
 
-func readFilesMetadata(vaultFile *os.File, key []byte) ([]FileMetadata, error) {
-	// Read the size of the files metadata
-	encryptedFilesMetadataEncryptedSize := make([]byte, utils.CipherBlockSize+unsafe.Sizeof(int32(0)))
-	_, err := vaultFile.Read(encryptedFilesMetadataEncryptedSize)
+func readHeaderBytes(vaultFile *os.File) ([]byte, error) {
+	// The header and the metadata end at the current position
+	headerSize, err := vaultFile.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
 
-	// Decrypt the size of the file metadata
-	encryptedFilesMetadataSizeBytes, err := utils.Decrypt(encryptedFilesMetadataEncryptedSize, key)
+	// Read them as they are in the file
+	headerBytes := make([]byte, headerSize)
+	_, err = vaultFile.ReadAt(headerBytes, 0)
 	if err != nil {
-		return nil, err
+		return nil, truncatedError(err)
 	}
 
-	// Decode the size of the file metadata
-	var encryptedFilesMetadataSize int32
-	err = utils.DecodeInt32FromBytes(encryptedFilesMetadataSizeBytes, &encryptedFilesMetadataSize)
+	return headerBytes, nil
+}
+
+func readVaultTrailer(vaultFile *os.File, headerSize int64) (*vaultTrailer, int64, error) {
+	// Get the size of the vault file
+	stat, err := vaultFile.Stat()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	vaultSize := stat.Size()
 
-	// Check incorrect size
-	if encryptedFilesMetadataSize < 0 {
-		return nil, fmt.Errorf("error while reading vault metadata")
-	}
+	trailer, err := readVaultTrailerAt(vaultFile, headerSize, vaultSize)
+	return trailer, vaultSize, err
+}
 
-	// Read the encrypted files metadata
-	encryptedFilesMetadata := make([]byte, encryptedFilesMetadataSize)
-	_, err = vaultFile.Read(encryptedFilesMetadata)
-	if err != nil {
-		return nil, err
+// Reads the trailer of the vault that ends at the given size of the vault file
+func readVaultTrailerAt(vaultFile *os.File, headerSize int64, vaultSize int64) (*vaultTrailer, error) {
+	// Check the trailer fits after the header
+	if vaultSize-trailerSize < headerSize {
+		return nil, fmt.Errorf("%w: no room for the vault trailer", ErrTruncated)
 	}
 
-	// Decrypt the files metadata
-	filesMetadataBytes, err := utils.Decrypt(encryptedFilesMetadata, key)
+	// Read the trailer at the end of the file
+	var trailer vaultTrailer
+	err := binary.Read(io.NewSectionReader(vaultFile, vaultSize-trailerSize, trailerSize), binary.LittleEndian, &trailer)
 	if err != nil {
-		return nil, err
+		return nil, truncatedError(err)
 	}
 
-	// Decode the files metadata
-	var filesMetadata []FileMetadata
-	err = utils.DecodeDataFromBytes(filesMetadataBytes, &filesMetadata)
-	if err != nil {
-		return nil, err
+	// Check the files metadata lies between the header and the trailer
+	if trailer.FilesMetadataOffset < headerSize || trailer.FilesMetadataSize < 0 {
+		return nil, fmt.Errorf("%w: no valid vault trailer at the end of the file", ErrTruncated)
+	}
+	if trailer.FilesMetadataSize > vaultSize-trailerSize-trailer.FilesMetadataOffset {
+		return nil, fmt.Errorf("%w: vault trailer points past the end of the vault file", ErrTruncated)
 	}
 
-	return filesMetadata, nil
+	return &trailer, nil
 }
 
-func readFiles(vaultFile *os.File) ([]byte, error) {
-	// Determine the size of the files data
-	filesStartOffset, err := vaultFile.Seek(0, io.SeekCurrent)
+func readEncryptedFilesMetadata(vaultFile *os.File, trailer *vaultTrailer) ([]byte, error) {
+	encryptedFilesMetadata := make([]byte, trailer.FilesMetadataSize)
+	_, err := vaultFile.ReadAt(encryptedFilesMetadata, trailer.FilesMetadataOffset)
 	if err != nil {
-		return nil, err
+		return nil, truncatedError(err)
 	}
-	stat, err := vaultFile.Stat()
+	return encryptedFilesMetadata, nil
+}
+
+func readFilesMetadata(encryptedFilesMetadata []byte, key []byte, cipherSuite utils.CipherSuite) (*filesIndex, error) {
+	// Decrypt the files metadata
+	filesMetadataBytes, err := utils.Decrypt(encryptedFilesMetadata, key, cipherSuite)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: files metadata can't be decrypted", ErrIntegrityMismatch)
 	}
-	totalVaultSize := stat.Size()
-	filesSize := totalVaultSize - filesStartOffset - int64(utils.HashSize)
 
-	// Read the files
-	files := make([]byte, filesSize)
-	_, err = vaultFile.Read(files)
+	// Decode the files metadata
+	var index filesIndex
+	err = utils.DecodeDataFromBytes(filesMetadataBytes, &index)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: files metadata can't be decoded: %v", ErrIntegrityMismatch, err)
 	}
 
-	return files, nil
+	return &index, nil
 }
 
-func readVaultHash(vaultFile *os.File) ([]byte, error) {
-	// Save the current file pointer position
-	currentPos, err := vaultFile.Seek(0, io.SeekCurrent)
+func checkVaultTrailer(vaultFile *os.File, key []byte) (bool, error) {
+	// Get the size of the vault file
+	stat, err := vaultFile.Stat()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	// Determine the size of the data
-	stat, err := vaultFile.Stat()
+	return checkVaultTrailerAt(vaultFile, key, stat.Size())
+}
+
+// Verifies the MAC of the vault that ends at the given size of the vault file
+func checkVaultTrailerAt(vaultFile *os.File, key []byte, vaultSize int64) (bool, error) {
+	// Read the header and the metadata as they are in the file
+	headerBytes, err := readHeaderBytes(vaultFile)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	totalVaultSize := stat.Size()
-	dataSize := totalVaultSize - int64(utils.HashSize)
 
-	// Seek to the start of the hash part
-	_, err = vaultFile.Seek(dataSize, io.SeekStart)
+	// Read the trailer and the files metadata it points to
+	trailer, err := readVaultTrailerAt(vaultFile, int64(len(headerBytes)), vaultSize)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-
-	// Read the vault hash
-	vaultHash := make([]byte, int64(utils.HashSize))
-	_, err = vaultFile.Read(vaultHash)
+	encryptedFilesMetadata, err := readEncryptedFilesMetadata(vaultFile, trailer)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	// Restore the file pointer to its original position
-	_, err = vaultFile.Seek(currentPos, io.SeekStart)
+	// Recompute the MAC
+	vaultMAC, err := computeVaultMAC(key, headerBytes, encryptedFilesMetadata, trailer)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	return vaultHash, nil
+	return hmac.Equal(vaultMAC, trailer.MAC[:]), nil
 }
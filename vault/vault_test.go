@@ -0,0 +1,224 @@
+package vault
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"secure_vault/vault/utils"
+	"strings"
+	"testing"
+)
+
+var (
+	testCredentials = Credentials{Password: "password"}
+	testKDFParams   = utils.KDFParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+)
+
+// Creates and saves an empty vault in a temporary folder
+func createTestVault(t *testing.T) (*Vault, []byte, string) {
+	t.Helper()
+
+	v, key, err := CreateVault(testCredentials, utils.DefaultCipherSuite, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultPath := filepath.Join(t.TempDir(), "test.vault")
+	err = SaveVault(v, key, vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseVault(v)
+	})
+	return v, key, vaultPath
+}
+
+func loadTestVault(t *testing.T, vaultPath string) (*Vault, []byte) {
+	t.Helper()
+
+	v, key, err := LoadVault(testCredentials, vaultPath, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		CloseVault(v)
+	})
+	return v, key
+}
+
+func saveTestVault(t *testing.T, v *Vault, key []byte, vaultPath string) {
+	t.Helper()
+
+	err := SaveVault(v, key, vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Adds a file with the content and returns its ID
+func addTestFile(t *testing.T, v *Vault, key []byte, name string, content []byte) string {
+	t.Helper()
+
+	err := AddStreamToVault(v, key, "", name, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileMetadata, err := FileByPath(v, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileMetadata.ID
+}
+
+// Checks the vault holds exactly the files, by ID
+func checkTestFiles(t *testing.T, v *Vault, key []byte, expected map[string][]byte) {
+	t.Helper()
+
+	if len(v.FilesMetadata) != len(expected) {
+		t.Fatalf("got %d files, want %d", len(v.FilesMetadata), len(expected))
+	}
+	for fileID, content := range expected {
+		var buf bytes.Buffer
+		err := ExtractFileToWriter(v, key, fileID, &buf)
+		if err != nil {
+			t.Fatalf("extract %s: %v", fileID, err)
+		}
+		if !bytes.Equal(buf.Bytes(), content) {
+			t.Errorf("content of %s does not match", fileID)
+		}
+		err = VerifyFile(v, key, fileID)
+		if err != nil {
+			t.Errorf("verify %s: %v", fileID, err)
+		}
+	}
+}
+
+func readTestVaultFile(t *testing.T, vaultPath string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAppendAndReload(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	expected := map[string][]byte{}
+
+	// First segment
+	expected[addTestFile(t, v, key, "first.txt", []byte("first"))] = []byte("first")
+	saveTestVault(t, v, key, vaultPath)
+	firstSave := readTestVaultFile(t, vaultPath)
+
+	// The second save appends a segment after the first one
+	content := bytes.Repeat([]byte("second"), utils.DefaultChunkSize/3)
+	expected[addTestFile(t, v, key, "second.bin", content)] = content
+	saveTestVault(t, v, key, vaultPath)
+	secondSave := readTestVaultFile(t, vaultPath)
+	if !bytes.HasPrefix(secondSave, firstSave) {
+		t.Fatal("the second save rewrote the vault instead of appending to it")
+	}
+	checkTestFiles(t, v, key, expected)
+
+	// Everything is read back from the appended segments
+	err := CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, expected)
+	if IsDirty(v) {
+		t.Error("reloaded vault has unsaved changes")
+	}
+}
+
+func TestRemoveAppendAndReload(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+
+	removedID := addTestFile(t, v, key, "removed.txt", []byte("removed"))
+	keptID := addTestFile(t, v, key, "kept.txt", []byte("kept"))
+	saveTestVault(t, v, key, vaultPath)
+
+	// Removing a file leaves its region as dead space
+	err := RemoveFileFromVault(v, removedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, map[string][]byte{keptID: []byte("kept")})
+	if DeadSpace(v) == 0 {
+		t.Error("no dead space recorded for the removed file")
+	}
+}
+
+func TestCompactVault(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	expected := map[string][]byte{}
+
+	// Leave dead space behind over a few saves
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		content := []byte(strings.Repeat(name, 1000))
+		expected[addTestFile(t, v, key, name, content)] = content
+		saveTestVault(t, v, key, vaultPath)
+	}
+	removedID := addTestFile(t, v, key, "removed.txt", []byte("removed"))
+	saveTestVault(t, v, key, vaultPath)
+	err := RemoveFileFromVault(v, removedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	sizeBefore := int64(len(readTestVaultFile(t, vaultPath)))
+
+	// Compaction drops the dead space and keeps the files and their IDs
+	err = CompactVault(v, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DeadSpace(v) != 0 {
+		t.Errorf("got %d bytes of dead space after compaction", DeadSpace(v))
+	}
+	if sizeAfter := int64(len(readTestVaultFile(t, vaultPath))); sizeAfter >= sizeBefore {
+		t.Errorf("compaction didn't shrink the vault: %d bytes, was %d", sizeAfter, sizeBefore)
+	}
+	checkTestFiles(t, v, key, expected)
+
+	err = CloseVault(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, key = loadTestVault(t, vaultPath)
+	checkTestFiles(t, v, key, expected)
+}
+
+func TestFileCheckInPieces(t *testing.T) {
+	v, key, vaultPath := createTestVault(t)
+	content := bytes.Repeat([]byte("piece"), utils.DefaultChunkSize/2)
+	fileID := addTestFile(t, v, key, "pieces.bin", content)
+
+	// The check goes on while the save moves the file from the staging file into the vault file
+	check := &FileCheck{FileID: fileID}
+	done, _, err := ContinueFileCheck(v, key, check, 1000)
+	if err != nil || done {
+		t.Fatalf("got done %v, error %v after the first piece", done, err)
+	}
+	saveTestVault(t, v, key, vaultPath)
+	for !done {
+		var valid bool
+		done, valid, err = ContinueFileCheck(v, key, check, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done && !valid {
+			t.Error("file moved during the check fails it")
+		}
+	}
+}